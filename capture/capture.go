@@ -0,0 +1,173 @@
+// Package capture records Serial API frames to a capture file and reads them
+// back, so that a session with a stick can be replayed later.
+//
+// A capture file is plain text. After a header line, every frame is written on
+// its own line as a timestamp, a direction and the frame bytes in hex:
+//
+//	# zwgo capture v1
+//	2022-07-04T18:21:09.512313Z out 01 03 00 15 e9
+//	2022-07-04T18:21:09.514901Z in 06
+//
+// PcapngWriter writes the same records as pcapng for standard packet tools.
+package capture
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jbielick/zwgo/transport"
+)
+
+const header = "# zwgo capture v1"
+
+type Direction int
+
+const (
+	// In is a frame received from the stick.
+	In Direction = iota
+	// Out is a frame sent to the stick.
+	Out
+)
+
+func (d Direction) String() string {
+	switch d {
+	case In:
+		return "in"
+	case Out:
+		return "out"
+	default:
+		return fmt.Sprintf("Direction(%d)", int(d))
+	}
+}
+
+func parseDirection(s string) (Direction, error) {
+	switch s {
+	case "in":
+		return In, nil
+	case "out":
+		return Out, nil
+	default:
+		return 0, fmt.Errorf("unknown direction %q", s)
+	}
+}
+
+// Record is a single captured frame.
+type Record struct {
+	Time      time.Time
+	Direction Direction
+	Frame     *transport.Frame
+}
+
+// Recorder records frames as they are sent and received. Writer and
+// PcapngWriter are Recorders.
+type Recorder interface {
+	Write(dir Direction, f *transport.Frame) error
+}
+
+// Writer appends records to a capture file. It is safe for concurrent use.
+type Writer struct {
+	w           io.Writer
+	mu          sync.Mutex
+	wroteHeader bool
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// Write records f as sent or received now.
+func (w *Writer) Write(dir Direction, f *transport.Frame) error {
+	return w.WriteRecord(&Record{Time: time.Now(), Direction: dir, Frame: f})
+}
+
+func (w *Writer) WriteRecord(r *Record) error {
+	data, err := r.Frame.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.wroteHeader {
+		if _, err := fmt.Fprintln(w.w, header); err != nil {
+			return err
+		}
+		w.wroteHeader = true
+	}
+	_, err = fmt.Fprintf(w.w, "%s %s % x\n", r.Time.UTC().Format(time.RFC3339Nano), r.Direction, data)
+	return err
+}
+
+// Reader reads records from a capture file.
+type Reader struct {
+	s    *bufio.Scanner
+	line int
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{s: bufio.NewScanner(r)}
+}
+
+// Next returns the next record, or io.EOF at the end of the capture.
+func (r *Reader) Next() (*Record, error) {
+	for r.s.Scan() {
+		r.line++
+		line := strings.TrimSpace(r.s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		record, err := parseRecord(line)
+		if err != nil {
+			return nil, fmt.Errorf("capture line %d: %w", r.line, err)
+		}
+		return record, nil
+	}
+	if err := r.s.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// ReadAll returns every remaining record.
+func (r *Reader) ReadAll() ([]*Record, error) {
+	var records []*Record
+	for {
+		record, err := r.Next()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, record)
+	}
+}
+
+func parseRecord(line string) (*Record, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("expected timestamp, direction and frame bytes: %q", line)
+	}
+	ts, err := time.Parse(time.RFC3339Nano, fields[0])
+	if err != nil {
+		return nil, err
+	}
+	dir, err := parseDirection(fields[1])
+	if err != nil {
+		return nil, err
+	}
+	data, err := hex.DecodeString(strings.Join(fields[2:], ""))
+	if err != nil {
+		return nil, err
+	}
+	frame := &transport.Frame{}
+	if err := frame.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return &Record{Time: ts, Direction: dir, Frame: frame}, nil
+}
@@ -0,0 +1,52 @@
+package capture
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jbielick/zwgo/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteAndRead(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	ts := time.Date(2022, 7, 4, 18, 21, 9, 512313000, time.UTC)
+	assert.NoError(t, w.WriteRecord(&Record{ts, Out, transport.NewRequest([]byte{0x15})}))
+	assert.NoError(t, w.WriteRecord(&Record{ts.Add(time.Millisecond), In, transport.NewACK()}))
+
+	assert.Equal(t, strings.Join([]string{
+		"# zwgo capture v1",
+		"2022-07-04T18:21:09.512313Z out 01 03 00 15 e9",
+		"2022-07-04T18:21:09.513313Z in 06",
+		"",
+	}, "\n"), buf.String())
+
+	records, err := NewReader(&buf).ReadAll()
+	assert.NoError(t, err)
+	assert.Len(t, records, 2)
+	assert.Equal(t, ts, records[0].Time)
+	assert.Equal(t, Out, records[0].Direction)
+	assert.True(t, records[0].Frame.IsRequest())
+	assert.Equal(t, []byte{0x15}, records[0].Frame.Payload)
+	assert.Equal(t, In, records[1].Direction)
+	assert.True(t, records[1].Frame.IsACK())
+}
+
+func TestReadErrors(t *testing.T) {
+	cases := map[string]string{
+		"MissingFields": "2022-07-04T18:21:09Z out",
+		"BadTime":       "yesterday out 06",
+		"BadDirection":  "2022-07-04T18:21:09Z sideways 06",
+		"BadHex":        "2022-07-04T18:21:09Z in 0g",
+		"BadChecksum":   "2022-07-04T18:21:09Z in 01 03 00 15 00",
+	}
+	for title, line := range cases {
+		t.Run(title, func(t *testing.T) {
+			_, err := NewReader(strings.NewReader(line)).Next()
+			assert.ErrorContains(t, err, "capture line 1")
+		})
+	}
+}
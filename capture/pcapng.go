@@ -0,0 +1,144 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/jbielick/zwgo/transport"
+)
+
+// LinkTypeZWaveSerial is the pcap link type for Serial API frames exchanged
+// between a host and a Z-Wave chip. Packet data is the raw frame: SOF, length,
+// type, function ID, payload and checksum, or a single ACK, NAK or CAN byte.
+const LinkTypeZWaveSerial = 287
+
+const (
+	blockTypeSectionHeader         = 0x0A0D0D0A
+	blockTypeInterface             = 0x00000001
+	blockTypeEnhancedPacket        = 0x00000006
+	byteOrderMagic                 = 0x1A2B3C4D
+	optionEndOfOptions             = 0
+	optionInterfaceName            = 2
+	optionPacketFlags              = 2
+	packetFlagInbound       uint32 = 1
+	packetFlagOutbound      uint32 = 2
+	snapLength                     = 0xFFFF
+)
+
+// PcapngWriter writes frames as a pcapng capture with a single Z-Wave serial
+// interface. Timestamps have microsecond resolution and every packet carries
+// its direction in the EPB flags. It is safe for concurrent use.
+type PcapngWriter struct {
+	w           io.Writer
+	mu          sync.Mutex
+	wroteHeader bool
+}
+
+func NewPcapngWriter(w io.Writer) *PcapngWriter {
+	return &PcapngWriter{w: w}
+}
+
+// Write records f as sent or received now.
+func (p *PcapngWriter) Write(dir Direction, f *transport.Frame) error {
+	return p.WriteRecord(&Record{Time: time.Now(), Direction: dir, Frame: f})
+}
+
+func (p *PcapngWriter) WriteRecord(r *Record) error {
+	data, err := r.Frame.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.wroteHeader {
+		if _, err := p.w.Write(sectionHeaderBlock()); err != nil {
+			return err
+		}
+		if _, err := p.w.Write(interfaceBlock("zwave")); err != nil {
+			return err
+		}
+		p.wroteHeader = true
+	}
+	_, err = p.w.Write(enhancedPacketBlock(r, data))
+	return err
+}
+
+// WritePcapng converts captured records to pcapng.
+func WritePcapng(w io.Writer, records []*Record) error {
+	p := NewPcapngWriter(w)
+	for _, r := range records {
+		if err := p.WriteRecord(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sectionHeaderBlock() []byte {
+	body := new(bytes.Buffer)
+	binary.Write(body, binary.LittleEndian, uint32(byteOrderMagic))
+	binary.Write(body, binary.LittleEndian, uint16(1)) // major version
+	binary.Write(body, binary.LittleEndian, uint16(0)) // minor version
+	binary.Write(body, binary.LittleEndian, int64(-1)) // section length unknown
+	return block(blockTypeSectionHeader, body.Bytes())
+}
+
+func interfaceBlock(name string) []byte {
+	body := new(bytes.Buffer)
+	binary.Write(body, binary.LittleEndian, uint16(LinkTypeZWaveSerial))
+	binary.Write(body, binary.LittleEndian, uint16(0)) // reserved
+	binary.Write(body, binary.LittleEndian, uint32(snapLength))
+	writeOption(body, optionInterfaceName, []byte(name))
+	writeOption(body, optionEndOfOptions, nil)
+	return block(blockTypeInterface, body.Bytes())
+}
+
+func enhancedPacketBlock(r *Record, data []byte) []byte {
+	flags := packetFlagInbound
+	if r.Direction == Out {
+		flags = packetFlagOutbound
+	}
+	ts := uint64(r.Time.UnixNano() / int64(time.Microsecond))
+
+	body := new(bytes.Buffer)
+	binary.Write(body, binary.LittleEndian, uint32(0)) // interface ID
+	binary.Write(body, binary.LittleEndian, uint32(ts>>32))
+	binary.Write(body, binary.LittleEndian, uint32(ts))
+	binary.Write(body, binary.LittleEndian, uint32(len(data))) // captured length
+	binary.Write(body, binary.LittleEndian, uint32(len(data))) // original length
+	body.Write(pad(data))
+	flagBytes := make([]byte, 4)
+	binary.LittleEndian.PutUint32(flagBytes, flags)
+	writeOption(body, optionPacketFlags, flagBytes)
+	writeOption(body, optionEndOfOptions, nil)
+	return block(blockTypeEnhancedPacket, body.Bytes())
+}
+
+func writeOption(buf *bytes.Buffer, code uint16, value []byte) {
+	binary.Write(buf, binary.LittleEndian, code)
+	binary.Write(buf, binary.LittleEndian, uint16(len(value)))
+	buf.Write(pad(value))
+}
+
+// block wraps body, which must already be padded to 32 bits, in a block type
+// and the leading and trailing total length.
+func block(blockType uint32, body []byte) []byte {
+	length := uint32(12 + len(body))
+	buf := bytes.NewBuffer(make([]byte, 0, length))
+	binary.Write(buf, binary.LittleEndian, blockType)
+	binary.Write(buf, binary.LittleEndian, length)
+	buf.Write(body)
+	binary.Write(buf, binary.LittleEndian, length)
+	return buf.Bytes()
+}
+
+func pad(b []byte) []byte {
+	if n := len(b) % 4; n != 0 {
+		return append(b[:len(b):len(b)], make([]byte, 4-n)...)
+	}
+	return b
+}
@@ -0,0 +1,63 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/jbielick/zwgo/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+type pcapngBlock struct {
+	Type uint32
+	Body []byte
+}
+
+func readBlocks(t *testing.T, data []byte) []pcapngBlock {
+	var blocks []pcapngBlock
+	for len(data) > 0 {
+		assert.GreaterOrEqual(t, len(data), 12)
+		blockType := binary.LittleEndian.Uint32(data[0:4])
+		length := binary.LittleEndian.Uint32(data[4:8])
+		assert.Zero(t, length%4)
+		assert.Equal(t, length, binary.LittleEndian.Uint32(data[length-4:length]))
+		blocks = append(blocks, pcapngBlock{blockType, data[8 : length-4]})
+		data = data[length:]
+	}
+	return blocks
+}
+
+func TestWritePcapng(t *testing.T) {
+	ts := time.Date(2022, 7, 4, 18, 21, 9, 512313000, time.UTC)
+	var buf bytes.Buffer
+	err := WritePcapng(&buf, []*Record{
+		{ts, Out, transport.NewRequest([]byte{0x15})},
+		{ts.Add(time.Millisecond), In, transport.NewACK()},
+	})
+	assert.NoError(t, err)
+
+	blocks := readBlocks(t, buf.Bytes())
+	assert.Len(t, blocks, 4)
+
+	assert.Equal(t, uint32(blockTypeSectionHeader), blocks[0].Type)
+	assert.Equal(t, uint32(byteOrderMagic), binary.LittleEndian.Uint32(blocks[0].Body[0:4]))
+
+	assert.Equal(t, uint32(blockTypeInterface), blocks[1].Type)
+	assert.Equal(t, uint16(LinkTypeZWaveSerial), binary.LittleEndian.Uint16(blocks[1].Body[0:2]))
+
+	request := blocks[2]
+	assert.Equal(t, uint32(blockTypeEnhancedPacket), request.Type)
+	micros := uint64(binary.LittleEndian.Uint32(request.Body[4:8]))<<32 | uint64(binary.LittleEndian.Uint32(request.Body[8:12]))
+	assert.Equal(t, uint64(ts.UnixNano()/1000), micros)
+	assert.Equal(t, uint32(5), binary.LittleEndian.Uint32(request.Body[12:16]))
+	assert.Equal(t, []byte("\x01\x03\x00\x15\xe9\x00\x00\x00"), request.Body[20:28])
+	assert.Equal(t, uint16(optionPacketFlags), binary.LittleEndian.Uint16(request.Body[28:30]))
+	assert.Equal(t, packetFlagOutbound, binary.LittleEndian.Uint32(request.Body[32:36]))
+
+	ack := blocks[3]
+	assert.Equal(t, uint32(1), binary.LittleEndian.Uint32(ack.Body[12:16]))
+	assert.Equal(t, []byte("\x06\x00\x00\x00"), ack.Body[20:24])
+	assert.Equal(t, packetFlagInbound, binary.LittleEndian.Uint32(ack.Body[28:32]))
+}
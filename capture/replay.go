@@ -0,0 +1,136 @@
+package capture
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/jbielick/zwgo/transport"
+)
+
+// Replayer plays a capture back as if it were the serial port of the stick.
+// Reads return the captured incoming frames; writes are checked against the
+// captured outgoing frames. An incoming frame is only made readable once every
+// outgoing frame recorded before it has been written, so a replay follows the
+// conversation regardless of the original timing.
+type Replayer struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	records []*Record
+	next    int
+	pending []byte
+	written bytes.Buffer
+	decoder *transport.Decoder
+	closed  bool
+	err     error
+}
+
+// NewReplayer reads the whole capture from r.
+func NewReplayer(r io.Reader) (*Replayer, error) {
+	records, err := NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	return NewRecordReplayer(records), nil
+}
+
+// NewRecordReplayer replays records that are already in memory.
+func NewRecordReplayer(records []*Record) *Replayer {
+	p := &Replayer{records: records}
+	p.cond = sync.NewCond(&p.mu)
+	p.decoder = transport.NewDecoder(&p.written)
+	p.decoder.FrameTimeout = 0
+	return p
+}
+
+// Read blocks until a captured incoming frame is due, then returns its bytes.
+// Once the capture is exhausted it blocks until the replayer is closed.
+func (p *Replayer) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for len(p.pending) == 0 {
+		if p.closed {
+			return 0, io.EOF
+		}
+		if p.next < len(p.records) && p.records[p.next].Direction == In {
+			data, err := p.records[p.next].Frame.MarshalBinary()
+			if err != nil {
+				return 0, err
+			}
+			p.pending = data
+			p.next++
+			continue
+		}
+		p.cond.Wait()
+	}
+	n := copy(b, p.pending)
+	p.pending = p.pending[n:]
+	return n, nil
+}
+
+// Write consumes b and compares every complete frame in it with the next
+// captured outgoing frame. The first mismatch is reported by Err.
+func (p *Replayer) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return 0, os.ErrClosed
+	}
+	p.written.Write(b)
+	for {
+		frame, err := p.decoder.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			p.fail(err)
+			continue
+		}
+		p.expect(frame)
+	}
+	p.cond.Broadcast()
+	return len(b), nil
+}
+
+func (p *Replayer) expect(frame *transport.Frame) {
+	if p.next >= len(p.records) || p.records[p.next].Direction != Out {
+		p.fail(fmt.Errorf("unexpected frame written during replay: %s", frame))
+		return
+	}
+	want, _ := p.records[p.next].Frame.MarshalBinary()
+	got, _ := frame.MarshalBinary()
+	if !bytes.Equal(want, got) {
+		p.fail(fmt.Errorf("frame %d: wrote %s, capture has %s", p.next, frame, p.records[p.next].Frame))
+	}
+	p.next++
+}
+
+func (p *Replayer) fail(err error) {
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+// Err returns the first divergence between the writes and the capture.
+func (p *Replayer) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// Remaining returns the number of records not yet replayed.
+func (p *Replayer) Remaining() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.records) - p.next
+}
+
+func (p *Replayer) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	p.cond.Broadcast()
+	return nil
+}
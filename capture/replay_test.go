@@ -0,0 +1,70 @@
+package capture
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jbielick/zwgo/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+const libraryVersionCapture = `# zwgo capture v1
+2022-07-04T18:21:09.512313Z out 01 03 00 15 e9
+2022-07-04T18:21:09.513313Z in 06
+2022-07-04T18:21:09.514313Z in 01 10 01 15 5a 2d 57 61 76 65 20 36 2e 30 37 00 01 97
+2022-07-04T18:21:09.515313Z out 06
+`
+
+func TestReplay(t *testing.T) {
+	p, err := NewReplayer(strings.NewReader(libraryVersionCapture))
+	assert.NoError(t, err)
+	d := transport.NewDecoder(p)
+	e := transport.NewEncoder(p)
+
+	_, err = e.Encode(transport.NewRequest([]byte{0x15}))
+	assert.NoError(t, err)
+	frame, err := d.Next()
+	assert.NoError(t, err)
+	assert.True(t, frame.IsACK())
+	frame, err = d.Next()
+	assert.NoError(t, err)
+	assert.True(t, frame.IsResponse())
+	assert.Equal(t, []byte("\x15Z-Wave 6.07\x00\x01"), frame.Payload)
+	_, err = e.Encode(transport.NewACK())
+	assert.NoError(t, err)
+
+	assert.NoError(t, p.Err())
+	assert.Equal(t, 0, p.Remaining())
+	assert.NoError(t, p.Close())
+	_, err = d.Next()
+	assert.Error(t, err)
+}
+
+func TestReplayWaitsForWrites(t *testing.T) {
+	p, err := NewReplayer(strings.NewReader(libraryVersionCapture))
+	assert.NoError(t, err)
+
+	read := make(chan *transport.Frame)
+	go func() {
+		frame, _ := transport.NewDecoder(p).Next()
+		read <- frame
+	}()
+	select {
+	case <-read:
+		t.Fatal("read an incoming frame before the request was written")
+	default:
+	}
+
+	_, err = transport.NewEncoder(p).Encode(transport.NewRequest([]byte{0x15}))
+	assert.NoError(t, err)
+	assert.True(t, (<-read).IsACK())
+}
+
+func TestReplayMismatch(t *testing.T) {
+	p, err := NewReplayer(strings.NewReader(libraryVersionCapture))
+	assert.NoError(t, err)
+
+	_, err = transport.NewEncoder(p).Encode(transport.NewRequest([]byte{0x07}))
+	assert.NoError(t, err)
+	assert.ErrorContains(t, p.Err(), "capture has Request{15}")
+}
@@ -0,0 +1,180 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jbielick/zwgo/transport"
+)
+
+// DefaultCallbackTimeout is used by SendWithCallback when neither the caller
+// nor the retry policy sets a timeout. Functions that report back later, such
+// as SendData, have overrides in DefaultRetryPolicy.
+const DefaultCallbackTimeout = 10 * time.Second
+
+// A Callback receives the callback frames reported for a request sent with
+// SendWithCallback. Some functions report progress through several frames, so
+// the callback returns true once it expects no more. If no frame arrives in
+// time, or the request's context is done first, it is called once with a nil
+// frame and an error.
+type Callback func(f *transport.Frame, err error) (done bool)
+
+type pendingCallback struct {
+	function transport.FunctionID
+	callback Callback
+	timer    *time.Timer
+	done     chan struct{}
+	// dispatching is set while a frame is handed to callback. A timeout or
+	// cancellation meanwhile is kept in expired and reported afterwards,
+	// unless the callback was done.
+	dispatching bool
+	expired     error
+}
+
+// callbackTable allocates callback IDs and routes callback frames to the
+// requests waiting for them. IDs run from 1 to 255 and wrap; 0 means the
+// caller wants no callback and is never allocated.
+type callbackTable struct {
+	mu      sync.Mutex
+	last    byte
+	pending map[byte]*pendingCallback
+}
+
+func newCallbackTable() *callbackTable {
+	return &callbackTable{pending: make(map[byte]*pendingCallback)}
+}
+
+// next returns the next free callback ID. It must be called with t.mu held.
+func (t *callbackTable) next() (byte, error) {
+	for i := 0; i < 255; i++ {
+		t.last++
+		if t.last == 0 {
+			t.last = 1
+		}
+		if _, ok := t.pending[t.last]; !ok {
+			return t.last, nil
+		}
+	}
+	return 0, fmt.Errorf("no free callback IDs")
+}
+
+// register allocates a callback ID for a request to function and arms its
+// timeout and cancellation by ctx.
+func (t *callbackTable) register(ctx context.Context, function transport.FunctionID, timeout time.Duration, callback Callback) (byte, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	id, err := t.next()
+	if err != nil {
+		return 0, err
+	}
+	p := &pendingCallback{function: function, callback: callback, done: make(chan struct{})}
+	p.timer = time.AfterFunc(timeout, func() {
+		t.expire(id, p, fmt.Errorf("%s callback %d: %w after %s", function, id, ErrCallbackTimeout, timeout))
+	})
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				t.expire(id, p, ctx.Err())
+			case <-p.done:
+			}
+		}()
+	}
+	t.pending[id] = p
+	return id, nil
+}
+
+// remove drops the entry for id if it is still p.
+func (t *callbackTable) remove(id byte, p *pendingCallback) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.pending[id] != p {
+		return false
+	}
+	t.drop(id, p)
+	return true
+}
+
+// drop deletes the entry for id. It must be called with t.mu held.
+func (t *callbackTable) drop(id byte, p *pendingCallback) {
+	p.timer.Stop()
+	close(p.done)
+	delete(t.pending, id)
+}
+
+// expire fails the entry for id with err if it is still p. If a frame is
+// being dispatched to it, dispatch reports err instead once the callback
+// returns.
+func (t *callbackTable) expire(id byte, p *pendingCallback, err error) {
+	t.mu.Lock()
+	if t.pending[id] != p {
+		t.mu.Unlock()
+		return
+	}
+	if p.dispatching {
+		if p.expired == nil {
+			p.expired = err
+		}
+		t.mu.Unlock()
+		return
+	}
+	t.drop(id, p)
+	t.mu.Unlock()
+	p.callback(nil, err)
+}
+
+func (t *callbackTable) cancel(id byte) {
+	t.mu.Lock()
+	p := t.pending[id]
+	t.mu.Unlock()
+	if p != nil {
+		t.remove(id, p)
+	}
+}
+
+// dispatch hands a callback frame, whose second payload byte is the callback
+// ID, to its waiter. It reports false if nobody is waiting for it.
+func (t *callbackTable) dispatch(f *transport.Frame) bool {
+	function, ok := f.FunctionID()
+	if !ok || len(f.Payload) < 2 {
+		return false
+	}
+	id := f.Payload[1]
+	t.mu.Lock()
+	p := t.pending[id]
+	if p == nil || p.function != function {
+		t.mu.Unlock()
+		return false
+	}
+	p.dispatching = true
+	t.mu.Unlock()
+
+	done := p.callback(f, nil)
+
+	t.mu.Lock()
+	p.dispatching = false
+	expired := p.expired
+	if done || expired != nil {
+		t.drop(id, p)
+	}
+	t.mu.Unlock()
+	if !done && expired != nil {
+		p.callback(nil, expired)
+	}
+	return true
+}
+
+// closeAll fails every pending callback with err.
+func (t *callbackTable) closeAll(err error) {
+	t.mu.Lock()
+	pending := t.pending
+	t.pending = make(map[byte]*pendingCallback)
+	t.mu.Unlock()
+	for _, p := range pending {
+		p.timer.Stop()
+		close(p.done)
+		p.callback(nil, err)
+	}
+}
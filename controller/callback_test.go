@@ -0,0 +1,150 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jbielick/zwgo/emulator"
+	"github.com/jbielick/zwgo/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+// rawCommand is a command given as its marshalled bytes.
+type rawCommand []byte
+
+func (r rawCommand) MarshalBinary() ([]byte, error) {
+	return append([]byte(nil), r...), nil
+}
+
+// rawReport keeps the payload of a response.
+type rawReport []byte
+
+func (r *rawReport) UnmarshalBinary(data []byte) error {
+	*r = append(rawReport(nil), data...)
+	return nil
+}
+
+func ignoreCallback(*transport.Frame, error) bool {
+	return true
+}
+
+func TestCallbackIDAllocation(t *testing.T) {
+	table := newCallbackTable()
+	table.last = 253
+	var ids []byte
+	for i := 0; i < 4; i++ {
+		id, err := table.register(context.Background(), transport.FuncSendData, time.Minute, ignoreCallback)
+		assert.NoError(t, err)
+		ids = append(ids, id)
+	}
+	assert.Equal(t, []byte{254, 255, 1, 2}, ids)
+
+	table.last = 0
+	id, err := table.register(context.Background(), transport.FuncSendData, time.Minute, ignoreCallback)
+	assert.NoError(t, err)
+	assert.Equal(t, byte(3), id, "IDs still pending are skipped")
+}
+
+func TestCallbackIDExhaustion(t *testing.T) {
+	table := newCallbackTable()
+	for i := 0; i < 255; i++ {
+		_, err := table.register(context.Background(), transport.FuncSendData, time.Minute, ignoreCallback)
+		assert.NoError(t, err)
+	}
+	_, err := table.register(context.Background(), transport.FuncSendData, time.Minute, ignoreCallback)
+	assert.ErrorContains(t, err, "no free callback IDs")
+}
+
+func TestCallbackDispatch(t *testing.T) {
+	table := newCallbackTable()
+	var got []*transport.Frame
+	id, err := table.register(context.Background(), transport.FuncAddNodeToNetwork, time.Minute, func(f *transport.Frame, err error) bool {
+		got = append(got, f)
+		return f.Payload[2] == 0x06
+	})
+	assert.NoError(t, err)
+
+	assert.False(t, table.dispatch(transport.NewRequest([]byte{0x13, id, 0x00})), "function must match")
+	assert.True(t, table.dispatch(transport.NewRequest([]byte{0x4a, id, 0x01})))
+	assert.True(t, table.dispatch(transport.NewRequest([]byte{0x4a, id, 0x06})))
+	assert.False(t, table.dispatch(transport.NewRequest([]byte{0x4a, id, 0x06})), "done callbacks are removed")
+	assert.Len(t, got, 2)
+}
+
+func TestCallbackTimeout(t *testing.T) {
+	table := newCallbackTable()
+	errs := make(chan error, 1)
+	id, err := table.register(context.Background(), transport.FuncSendData, 10*time.Millisecond, func(f *transport.Frame, err error) bool {
+		assert.Nil(t, f)
+		errs <- err
+		return true
+	})
+	assert.NoError(t, err)
+	assert.ErrorIs(t, <-errs, ErrCallbackTimeout)
+	assert.False(t, table.dispatch(transport.NewRequest([]byte{0x13, id, 0x00})))
+}
+
+func TestCallbackTimeoutDuringDispatch(t *testing.T) {
+	for _, done := range []bool{true, false} {
+		table := newCallbackTable()
+		var calls []error
+		id, err := table.register(context.Background(), transport.FuncAddNodeToNetwork, 10*time.Millisecond, func(f *transport.Frame, err error) bool {
+			calls = append(calls, err)
+			if f != nil {
+				// the timeout expires while the frame is handled
+				time.Sleep(50 * time.Millisecond)
+			}
+			return done
+		})
+		assert.NoError(t, err)
+		assert.True(t, table.dispatch(transport.NewRequest([]byte{0x4a, id, 0x01})))
+		time.Sleep(20 * time.Millisecond)
+
+		if done {
+			assert.Equal(t, []error{nil}, calls, "a done callback is not failed afterwards")
+		} else if assert.Len(t, calls, 2) {
+			assert.ErrorIs(t, calls[1], ErrCallbackTimeout)
+		}
+		assert.False(t, table.dispatch(transport.NewRequest([]byte{0x4a, id, 0x01})))
+	}
+}
+
+func TestSendWithCallback(t *testing.T) {
+	c, stick := openEmulator(t, emulator.DefaultProfile())
+
+	callbacks := make(chan *transport.Frame, 1)
+	var resp rawReport
+	err := c.SendWithCallback(
+		context.Background(),
+		rawCommand{0x13, 0x02, 0x02, 0x20, 0x02, 0x25, 0x00},
+		&resp,
+		time.Second,
+		func(f *transport.Frame, err error) bool {
+			assert.NoError(t, err)
+			callbacks <- f
+			return true
+		},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, rawReport{0x13, 0x01}, resp)
+
+	callback := <-callbacks
+	assert.Equal(t, []byte{0x13, 0x01, 0x00}, callback.Payload)
+	requests := stick.Requests()
+	assert.Equal(t, byte(0x01), requests[len(requests)-1].Payload[6], "callback ID is written into the last byte")
+}
+
+func TestCallbackCancelledByContext(t *testing.T) {
+	table := newCallbackTable()
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	id, err := table.register(ctx, transport.FuncSendData, time.Minute, func(f *transport.Frame, err error) bool {
+		errs <- err
+		return true
+	})
+	assert.NoError(t, err)
+	cancel()
+	assert.ErrorIs(t, <-errs, context.Canceled)
+	assert.False(t, table.dispatch(transport.NewRequest([]byte{0x13, id, 0x00})))
+}
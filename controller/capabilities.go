@@ -0,0 +1,127 @@
+package controller
+
+import (
+	"fmt"
+
+	"github.com/jbielick/zwgo/hostapi/capabilities/v0"
+	"github.com/jbielick/zwgo/logging"
+	"github.com/jbielick/zwgo/transport"
+)
+
+// Capabilities is the stick's answer to CAPABILITIES_GET.
+type Capabilities struct {
+	// Version and Revision are the Serial API's.
+	Version        byte
+	Revision       byte
+	ManufacturerID uint16
+	ProductType    uint16
+	ProductID      uint16
+	// functions has bit n-1 set for every function ID n the stick supports.
+	functions []byte
+}
+
+// Supports reports whether the stick implements function id.
+func (c Capabilities) Supports(id transport.FunctionID) bool {
+	if id == 0 {
+		return false
+	}
+	i := int(id-1) / 8
+	return i < len(c.functions) && c.functions[i]&(1<<((id-1)%8)) != 0
+}
+
+// Functions returns the functions the stick implements, in ascending order.
+func (c Capabilities) Functions() []transport.FunctionID {
+	var ids []transport.FunctionID
+	for i, b := range c.functions {
+		for bit := 0; bit < 8; bit++ {
+			// bit 7 of byte 31 would be function 256
+			if id := i*8 + bit + 1; b&(1<<bit) != 0 && id <= 0xff {
+				ids = append(ids, transport.FunctionID(id))
+			}
+		}
+	}
+	return ids
+}
+
+func decodeCapabilities(r capabilities.Report) Capabilities {
+	return Capabilities{
+		Version:        r.Version,
+		Revision:       r.Revision,
+		ManufacturerID: r.ManufacturerID,
+		ProductType:    r.ProductType,
+		ProductID:      r.ProductID,
+		functions:      append([]byte(nil), r.SupportedCommands...),
+	}
+}
+
+// Bits of CONTROLLER_CAPABILITIES_REPORT.
+const (
+	controllerSecondary    = 0x01
+	controllerOtherNetwork = 0x02
+	controllerSISPresent   = 0x04
+	controllerRealPrimary  = 0x08
+	controllerSUC          = 0x10
+)
+
+// ControllerCapabilities is the stick's answer to
+// GET_CONTROLLER_CAPABILITIES.
+type ControllerCapabilities struct {
+	// Secondary means the stick is not the network's primary controller.
+	Secondary bool
+	// OtherNetwork means the stick was included in a network it did not
+	// start.
+	OtherNetwork bool
+	// SISPresent means the network has a SUC ID server.
+	SISPresent bool
+	// RealPrimary means the stick was the primary controller when the
+	// network was created.
+	RealPrimary bool
+	// SUC means the stick is the network's static update controller.
+	SUC bool
+}
+
+func decodeControllerCapabilities(r capabilities.ControllerReport) ControllerCapabilities {
+	return ControllerCapabilities{
+		Secondary:    r.Capabilities&controllerSecondary != 0,
+		OtherNetwork: r.Capabilities&controllerOtherNetwork != 0,
+		SISPresent:   r.Capabilities&controllerSISPresent != 0,
+		RealPrimary:  r.Capabilities&controllerRealPrimary != 0,
+		SUC:          r.Capabilities&controllerSUC != 0,
+	}
+}
+
+// primaryFunctions are the network management functions only a primary
+// controller may use.
+var primaryFunctions = map[transport.FunctionID]bool{
+	transport.FuncAddNodeToNetwork:      true,
+	transport.FuncRemoveNodeFromNetwork: true,
+	transport.FuncRemoveFailedNode:      true,
+}
+
+// optional reports whether the stick supports id, for initialization queries
+// that are skipped on sticks that do not.
+func (c *Controller) optional(id transport.FunctionID) bool {
+	if c.checkSupported([]byte{byte(id)}) == nil {
+		return true
+	}
+	c.log.Log(logging.Info, "skipping query the stick does not support", logging.F("function", id))
+	return false
+}
+
+// checkSupported fails requests of functions the stick reported it does not
+// implement, and network management requests to a secondary controller.
+// Until the stick has reported its capabilities, every function is allowed.
+func (c *Controller) checkSupported(cmdBytes []byte) error {
+	if len(cmdBytes) < 1 {
+		return nil
+	}
+	info := c.stickInfo()
+	id := transport.FunctionID(cmdBytes[0])
+	if info.capabilities.functions != nil && !info.capabilities.Supports(id) {
+		return fmt.Errorf("%s: %w", id, ErrUnsupported)
+	}
+	if info.controllerCapabilities.Secondary && primaryFunctions[id] {
+		return fmt.Errorf("%s: %w", id, ErrNotPrimary)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jbielick/zwgo/emulator"
+	"github.com/jbielick/zwgo/hostapi/capabilities/v0"
+	"github.com/jbielick/zwgo/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeCapabilities(t *testing.T) {
+	functions := make([]byte, 32)
+	functions[0] = 0x42  // 0x02, 0x07
+	functions[31] = 0xc0 // 0xff and the nonexistent 0x100
+	caps := decodeCapabilities(capabilities.Report{
+		Version:           0x01,
+		Revision:          0x02,
+		ManufacturerID:    0x0086,
+		ProductType:       0x0101,
+		ProductID:         0x005a,
+		SupportedCommands: functions,
+	})
+	assert.Equal(t, uint16(0x0086), caps.ManufacturerID)
+	assert.Equal(t, uint16(0x0101), caps.ProductType)
+	assert.Equal(t, uint16(0x005a), caps.ProductID)
+	assert.True(t, caps.Supports(transport.FuncInitData))
+	assert.True(t, caps.Supports(transport.FuncCapabilities))
+	assert.True(t, caps.Supports(0xff))
+	assert.False(t, caps.Supports(transport.FuncSendData))
+	assert.False(t, caps.Supports(0))
+	assert.Equal(t, []transport.FunctionID{transport.FuncInitData, transport.FuncCapabilities, 0xff}, caps.Functions())
+}
+
+func TestCapabilitiesReportWords(t *testing.T) {
+	report := capabilities.Report{ManufacturerID: 0x0086, ProductType: 0x0101, ProductID: 0x005a, SupportedCommands: make([]byte, 32)}
+	data, err := report.MarshalBinary()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x07, 0x00, 0x00, 0x00, 0x86, 0x01, 0x01, 0x00, 0x5a}, data[:9])
+
+	var decoded capabilities.Report
+	assert.NoError(t, decoded.UnmarshalBinary(data))
+	assert.Equal(t, report, decoded)
+}
+
+func TestOpenSkipsUnsupportedQueries(t *testing.T) {
+	profile := emulator.DefaultProfile()
+	var functions []transport.FunctionID
+	for _, id := range profile.Functions {
+		if id != transport.FuncMemoryGetID && id != transport.FuncControllerCapabilities {
+			functions = append(functions, id)
+		}
+	}
+	profile.Functions = functions
+	c, stick := openEmulator(t, profile)
+
+	assert.Len(t, stick.Requests(), initRequests-2)
+	assert.Equal(t, []NodeID{1}, c.InitData().Nodes)
+	assert.Zero(t, c.HomeID())
+}
+
+func TestUnsupportedFunction(t *testing.T) {
+	profile := emulator.DefaultProfile()
+	c, stick := openEmulator(t, profile)
+
+	assert.Equal(t, profile.ManufacturerID, c.Capabilities().ManufacturerID)
+	assert.Equal(t, profile.ProductID, c.Capabilities().ProductID)
+	assert.True(t, c.Capabilities().Supports(transport.FuncSendData))
+	assert.False(t, c.Capabilities().Supports(transport.FuncRequestNodeNeighborUpdate))
+
+	requests := len(stick.Requests())
+	_, err := c.SendWithAcknowledgement(context.Background(), rawCommand{0x48, 0x02, 0x00})
+	assert.ErrorIs(t, err, ErrUnsupported)
+	assert.EqualError(t, err, "REQUEST_NODE_NEIGHBOR_UPDATE: unsupported by this stick firmware")
+	var resp rawReport
+	assert.ErrorIs(t, c.SendAndReceive(context.Background(), rawCommand{0x48}, &resp), ErrUnsupported)
+	assert.ErrorIs(t, c.SendWithCallback(context.Background(), rawCommand{0x48, 0x02, 0x00}, nil, 0, nil), ErrUnsupported)
+	assert.Len(t, stick.Requests(), requests, "nothing is sent to the stick")
+}
+
+func TestDecodeControllerCapabilities(t *testing.T) {
+	caps := decodeControllerCapabilities(capabilities.ControllerReport{Capabilities: 0x15})
+	assert.Equal(t, ControllerCapabilities{Secondary: true, SISPresent: true, SUC: true}, caps)
+	caps = decodeControllerCapabilities(capabilities.ControllerReport{Capabilities: 0x0a})
+	assert.Equal(t, ControllerCapabilities{OtherNetwork: true, RealPrimary: true}, caps)
+}
+
+func TestSecondaryRefusesNetworkManagement(t *testing.T) {
+	profile := emulator.DefaultProfile()
+	profile.ControllerCapabilities = 0x01
+	c, stick := openEmulator(t, profile)
+
+	assert.True(t, c.ControllerCapabilities().Secondary)
+	requests := len(stick.Requests())
+	for _, function := range []transport.FunctionID{
+		transport.FuncAddNodeToNetwork,
+		transport.FuncRemoveNodeFromNetwork,
+		transport.FuncRemoveFailedNode,
+	} {
+		err := c.SendWithCallback(context.Background(), rawCommand{byte(function), 0x01, 0x00}, nil, 0, nil)
+		assert.ErrorIs(t, err, ErrNotPrimary, function.String())
+	}
+	_, err := c.SendWithAcknowledgement(context.Background(), rawCommand{0x4a, 0x01, 0x00})
+	assert.EqualError(t, err, "ADD_NODE_TO_NETWORK: requires a primary controller, but the stick is secondary")
+	assert.Len(t, stick.Requests(), requests, "nothing is sent to the stick")
+
+	// other requests still go through
+	_, err = capabilities.NewLibraryVersionGet().SendContext(context.Background(), c)
+	assert.NoError(t, err)
+}
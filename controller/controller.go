@@ -1,40 +1,101 @@
 package controller
 
 import (
+	"context"
 	"encoding"
+	"errors"
 	"fmt"
-	"io"
 	"sync"
 	"time"
 
+	"github.com/jbielick/zwgo/capture"
 	"github.com/jbielick/zwgo/hostapi/capabilities/v0"
+	"github.com/jbielick/zwgo/logging"
+	"github.com/jbielick/zwgo/port"
 	"github.com/jbielick/zwgo/transport"
-	log "github.com/sirupsen/logrus"
 
 	"github.com/tarm/serial"
 )
 
 type Config struct {
 	Serial serial.Config
+	// FrameTimeout is the receive timeout for a single data frame.
+	FrameTimeout time.Duration
+	// Capture, if set, records every frame sent and received.
+	Capture capture.Recorder
+	// OpenPort opens the port described by Serial. When nil, port.Open picks
+	// an Opener from the URL scheme of Serial.Name.
+	OpenPort port.Opener
+	// ReconnectBackoff is the delay before the first attempt to reopen the
+	// port after the connection to the stick is lost. It doubles after each
+	// failed attempt up to MaxReconnectBackoff. Zero disables reconnecting.
+	ReconnectBackoff    time.Duration
+	MaxReconnectBackoff time.Duration
+	// OnStateChange, if set, is called whenever the connection state changes.
+	OnStateChange func(StateEvent)
+	// SoftReset restarts the stick's Serial API before initializing it, so
+	// it starts from a known state. StartTimeout bounds the wait for the
+	// stick to report that it started; zero means DefaultStartTimeout.
+	SoftReset    bool
+	StartTimeout time.Duration
+	// Retry sets the timeouts and retransmissions of requests.
+	Retry RetryPolicy
+	// MetricsAddr, if set, is the address to serve Prometheus metrics on,
+	// at /metrics.
+	MetricsAddr string
+	// Logger receives the controller's log entries, attributed to the
+	// "controller" and "transport" subsystems. Nil logs nothing; wrap a
+	// logger in logging.Levels to set levels per subsystem.
+	Logger logging.Logger
 }
 
 type Controller struct {
-	Config         Config
-	Port           *serial.Port
-	mu             sync.Mutex
-	LibraryVersion capabilities.LibraryVersionReport
-	// InitData               capabilities.InitDataReport
-	Capabilities capabilities.Report
-	// ControllerCapabilities capabilities.ControllerCapabilities
-	inbox       chan *transport.Frame
-	unsolicited chan *transport.Frame
+	Config        Config
+	Port          port.Port
+	queue         *queue
+	metrics       *controllerMetrics
+	log           logging.Logger
+	infoMu        sync.RWMutex
+	info          stickInfo
+	inbox         chan *transport.Frame
+	unsolicited   chan *transport.Frame
+	encoder       *transport.Encoder
+	pendingMu     sync.Mutex
+	captureMu     sync.Mutex
+	pending       *pendingResponse
+	callbacks     *callbackTable
+	subscriptions *subscriptions
+	done          chan struct{}
+	closeOnce     *sync.Once
+	wg            sync.WaitGroup
+	connMu        sync.RWMutex
+	state         ConnectionState
+	down          chan struct{}
+	lost          chan error
+	startMu       sync.Mutex
+	starting      chan *SerialAPIStarted
+	restarted     chan *SerialAPIStarted
+}
+
+// pendingResponse is the response frame an outstanding request waits for.
+type pendingResponse struct {
+	id transport.FunctionID
+	ch chan *transport.Frame
 }
 
 func New(config Config) *Controller {
+	q := newQueue()
 	return &Controller{
-		Config:      config,
-		inbox:       make(chan *transport.Frame, 20),
-		unsolicited: make(chan *transport.Frame, 20),
+		Config:        config,
+		queue:         q,
+		metrics:       newControllerMetrics(q),
+		log:           logging.Subsystem(logging.OrNop(config.Logger), "controller"),
+		inbox:         make(chan *transport.Frame, 20),
+		unsolicited:   make(chan *transport.Frame, 20),
+		callbacks:     newCallbackTable(),
+		subscriptions: newSubscriptions(),
+		lost:          make(chan error, 1),
+		restarted:     make(chan *SerialAPIStarted, 1),
 	}
 }
 
@@ -48,49 +109,171 @@ func NewConfig(port string) Config {
 			Size:        8,
 			StopBits:    1,
 		},
+		FrameTimeout:        transport.DefaultFrameTimeout,
+		ReconnectBackoff:    DefaultReconnectBackoff,
+		MaxReconnectBackoff: DefaultMaxReconnectBackoff,
+		SoftReset:           true,
+		StartTimeout:        DefaultStartTimeout,
+		Retry:               DefaultRetryPolicy(),
 	}
 }
 
-func (c *Controller) Open() error {
-	port, err := serial.OpenPort(&c.Config.Serial)
-	if err != nil {
+// Open opens the port, soft resets the stick if Config.SoftReset is set and
+// retrieves the stick's initialization data. ctx
+// bounds the initialization; the controller keeps running after it is done.
+// If the connection is lost later, the controller reopens the port and
+// initializes the stick again.
+func (c *Controller) Open(ctx context.Context) error {
+	c.done = make(chan struct{})
+	c.closeOnce = new(sync.Once)
+	c.subscriptions.reopen()
+	if err := c.connect(); err != nil {
 		return err
 	}
-	c.Port = port
-	c.Port.Flush()
+	if c.Config.MetricsAddr != "" {
+		if err := c.serveMetrics(); err != nil {
+			c.Close()
+			return err
+		}
+	}
 
-	go c.receive()
+	c.wg.Add(2)
 	go c.handleRequests()
+	go c.supervise()
 
-	if err := c.initialize(); err != nil {
+	if err := c.start(ctx); err != nil {
+		c.Close()
 		return err
 	}
+	c.setState(Connected, nil)
 
 	return nil
 }
 
-func (c *Controller) receive() {
-	d := transport.NewDecoder(c.Port)
+// closed reports whether Close has been called.
+func (c *Controller) closed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// receive reads frames from p until reading fails. A failure other than
+// closing the controller marks the connection identified by down as lost.
+func (c *Controller) receive(p port.Port, down chan struct{}) {
+	defer c.wg.Done()
+	d := transport.NewDecoder(p)
+	d.FrameTimeout = c.Config.FrameTimeout
+	d.Logger = logging.Subsystem(logging.OrNop(c.Config.Logger), "transport")
 	for {
 		frame, err := d.Next()
-		if err == io.EOF {
-			log.Error(err)
+		if err != nil {
+			var checksum *transport.ChecksumError
+			if errors.As(err, &checksum) {
+				c.metrics.checksumErrors.Inc()
+			}
+			var corrupt *transport.CorruptFrameError
+			if errors.As(err, &corrupt) {
+				c.log.Log(logging.Warn, "dropped corrupt frame", logging.F("error", err))
+				if _, err := c.Send(transport.NewNAK()); err != nil {
+					c.log.Log(logging.Error, "failed to send NAK", logging.F("error", err))
+				}
+				continue
+			}
+			var timeout *transport.FrameTimeoutError
+			if errors.As(err, &timeout) {
+				c.log.Log(logging.Warn, "dropped incomplete frame", logging.F("error", err))
+				continue
+			}
+			if !c.closed() {
+				c.disconnected(down, err)
+			}
 			return
 		}
-		log.Debugf("← %s", frame)
-		if frame.IsRequest() {
-			c.unsolicited <- frame
-		} else {
-			c.inbox <- frame
+		c.log.Log(logging.Debug, "received frame", frameFields(capture.In, frame)...)
+		c.captureMu.Lock()
+		c.record(capture.In, frame)
+		c.captureMu.Unlock()
+		c.metrics.framesIn.With(frame.FrameType.String()).Inc()
+		if !frame.IsDataFrame() {
+			select {
+			case c.inbox <- frame:
+			case <-c.done:
+				return
+			}
+			continue
+		}
+		if err := c.ack(); err != nil {
+			c.log.Log(logging.Error, "failed to send ACK", logging.F("error", err))
 		}
-		// @TODO shutdown channel
+		if frame.IsResponse() {
+			if c.deliverResponse(frame) {
+				continue
+			}
+			c.log.Log(logging.Warn, "received response without a matching request", frameFields(capture.In, frame)...)
+		}
+		select {
+		case c.unsolicited <- frame:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// expectResponse registers the function ID of the request about to be sent
+// and returns the channel its response will be delivered on.
+func (c *Controller) expectResponse(id transport.FunctionID) chan *transport.Frame {
+	ch := make(chan *transport.Frame, 1)
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+	c.pending = &pendingResponse{id: id, ch: ch}
+	return ch
+}
+
+func (c *Controller) clearResponse() {
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+	c.pending = nil
+}
+
+// deliverResponse hands f to the outstanding request with the same function
+// ID. It reports false if no such request is waiting.
+func (c *Controller) deliverResponse(f *transport.Frame) bool {
+	id, _ := f.FunctionID()
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+	if c.pending == nil || c.pending.id != id {
+		return false
 	}
+	c.pending.ch <- f
+	c.pending = nil
+	return true
 }
 
 func (c *Controller) handleRequests() {
+	defer c.wg.Done()
 	for {
-		req := <-c.unsolicited
-		log.Printf("handleRequest: %q", req)
+		var req *transport.Frame
+		select {
+		case req = <-c.unsolicited:
+		case <-c.done:
+			return
+		}
+		if !req.IsRequest() {
+			continue
+		}
+		if c.callbacks.dispatch(req) {
+			continue
+		}
+		e := decodeEvent(req)
+		if started, ok := e.(*SerialAPIStarted); ok {
+			c.handleStarted(started)
+		}
+		if !c.subscriptions.publish(e) {
+			c.log.Log(logging.Debug, "no subscriber for request", frameFields(capture.In, req)...)
+		}
 	}
 }
 
@@ -99,125 +282,364 @@ func (c *Controller) ack() error {
 	return err
 }
 
+// Send writes f to the stick. Only frames written successfully are logged
+// and captured.
 func (c *Controller) Send(f *transport.Frame) (int, error) {
-	bytes, err := f.MarshalBinary()
+	encoder, down := c.connection()
+	if encoder == nil {
+		return 0, ErrDisconnected
+	}
+	// capturing under the same lock as received frames keeps a response from
+	// being recorded ahead of the frame it answers
+	c.captureMu.Lock()
+	n, err := encoder.Encode(f)
+	if err == nil {
+		c.record(capture.Out, f)
+	}
+	c.captureMu.Unlock()
 	if err != nil {
-		return 0, err
+		if !c.closed() {
+			c.disconnected(down, err)
+		}
+		return n, err
 	}
-	log.Debugf("→ %s", f)
-	return c.Port.Write(bytes)
+	c.log.Log(logging.Debug, "sent frame", frameFields(capture.Out, f)...)
+	c.metrics.framesOut.With(f.FrameType.String()).Inc()
+	return n, nil
 }
 
-func (c *Controller) sendWithAcknowledgementUnlocked(cmd encoding.BinaryMarshaler) (int, error) {
-	attempts := 0
-	cmdBytes, err := cmd.MarshalBinary()
-	if err != nil {
-		return 0, err
+// record writes f to Config.Capture, if set. Callers hold captureMu.
+func (c *Controller) record(dir capture.Direction, f *transport.Frame) {
+	if c.Config.Capture == nil {
+		return
+	}
+	if err := c.Config.Capture.Write(dir, f); err != nil {
+		c.log.Log(logging.Warn, "failed to capture frame", append(frameFields(dir, f), logging.F("error", err))...)
+	}
+}
+
+// frameFields describes f for a log entry.
+func frameFields(dir capture.Direction, f *transport.Frame) []logging.Field {
+	fields := []logging.Field{logging.F("direction", dir), logging.F("frame", f)}
+	if id, ok := f.FunctionID(); ok {
+		fields = append(fields, logging.F("function", id))
+	}
+	return fields
+}
+
+// flush discards unread and unwritten data if the port supports it.
+func (c *Controller) flush() {
+	c.connMu.RLock()
+	p := c.Port
+	c.connMu.RUnlock()
+	if err := port.Flush(p); err != nil && err != port.ErrUnsupported {
+		c.log.Log(logging.Warn, "failed to flush port", logging.F("error", err))
+	}
+}
+
+// lock waits in the transmit queue for exclusive use of the port for a
+// transaction, at the priority and for the node set on ctx. It fails fast
+// while the stick is disconnected.
+func (c *Controller) lock(ctx context.Context) error {
+	if c.State() == Disconnected && !c.closed() {
+		return ErrDisconnected
+	}
+	if err := c.queue.acquire(ctx, c.done, priorityFrom(ctx), nodeFrom(ctx)); err != nil {
+		return err
+	}
+	if c.closed() {
+		c.queue.release()
+		return ErrClosed
+	}
+	if c.State() == Disconnected {
+		c.queue.release()
+		return ErrDisconnected
+	}
+	return nil
+}
+
+func (c *Controller) unlock() {
+	c.queue.release()
+}
+
+// sleep waits for d or until ctx is done or the controller is closed.
+func (c *Controller) sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.done:
+		return ErrClosed
+	}
+}
+
+// discardStale drops ACK, NAK and CAN frames left over from a transaction
+// that was abandoned before the stick answered.
+func (c *Controller) discardStale() {
+	for {
+		select {
+		case frame := <-c.inbox:
+			c.log.Log(logging.Debug, "discarding stale frame", frameFields(capture.In, frame)...)
+		default:
+			return
+		}
 	}
+}
+
+func (c *Controller) sendWithAcknowledgementUnlocked(ctx context.Context, cmdBytes []byte) (int, error) {
+	if len(cmdBytes) < 1 {
+		return 0, fmt.Errorf("cannot send empty command")
+	}
+	policy := c.Config.Retry.For(transport.FunctionID(cmdBytes[0]))
+	for n := 0; ; n++ {
+		if n > 0 {
+			if err := c.sleep(ctx, policy.Backoff(n)); err != nil {
+				return 0, err
+			}
+		}
+		sent, retry, err := c.transmit(ctx, cmdBytes, policy)
+		if err == nil || !retry || n >= policy.MaxRetransmits {
+			return sent, err
+		}
+		c.log.Log(logging.Warn, "retransmitting request",
+			logging.F("function", transport.FunctionID(cmdBytes[0])),
+			logging.F("node", nodeFrom(ctx)),
+			logging.F("attempt", n+1),
+			logging.F("max", policy.MaxRetransmits),
+			logging.F("error", err))
+		c.metrics.retransmissions.With(retransmitReason(err)).Inc()
+	}
+}
 
-retry:
-	sent, err := c.Send(transport.NewRequest(cmdBytes))
+// transmit sends a request frame once and waits for the stick to acknowledge
+// it. retry reports whether the frame may be retransmitted after an error.
+func (c *Controller) transmit(ctx context.Context, cmdBytes []byte, policy RetryPolicy) (sent int, retry bool, err error) {
+	c.discardStale()
+	_, down := c.connection()
+	start := time.Now()
+	sent, err = c.Send(transport.NewRequest(cmdBytes))
 	if err != nil {
-		return sent, err
+		return sent, false, err
 	}
 
+	t := time.NewTimer(policy.AckTimeout)
+	defer t.Stop()
 	select {
 	case frame := <-c.inbox:
 		switch frame.FrameType {
 		case transport.ACK:
-			return sent, nil
+			c.metrics.ackLatency.Observe(time.Since(start).Seconds())
+			return sent, false, nil
 		case transport.NAK:
-			err = fmt.Errorf("Messsage was not acknowledged by the device")
+			return sent, true, ErrNAK
 		case transport.CAN:
-			err = fmt.Errorf("Received CAN while waiting for acknowledgement: %s", frame)
-			log.Warn(err)
-			time.Sleep(1 * time.Second)
+			if policy.NoRetryOnCAN {
+				return sent, false, ErrCAN
+			}
+			if policy.CANDelay > 0 {
+				if err := c.sleep(ctx, policy.CANDelay); err != nil {
+					return sent, false, err
+				}
+			}
+			return sent, true, ErrCAN
 		default:
-			return sent, fmt.Errorf("Received unexpected frame waiting for acknowledgement: %s", frame)
+			return sent, false, fmt.Errorf("received unexpected frame waiting for acknowledgement: %s", frame)
 		}
-	case <-time.After(2 * time.Second):
-		err = fmt.Errorf("Timed out waiting for acknowledgement")
+	case <-t.C:
+		return sent, true, ErrAckTimeout
+	case <-ctx.Done():
+		return sent, false, ctx.Err()
+	case <-c.done:
+		return sent, false, ErrClosed
+	case <-down:
+		return sent, false, c.connErr()
 	}
-	if err == nil || attempts >= 2 {
-		return sent, err
-	} else {
-		attempts++
-		time.Sleep(100*time.Millisecond + time.Duration(attempts)*time.Second)
-		goto retry
+}
+
+func (c *Controller) SendWithAcknowledgement(ctx context.Context, cmd encoding.BinaryMarshaler) (int, error) {
+	cmdBytes, err := cmd.MarshalBinary()
+	if err != nil {
+		return 0, err
+	}
+	if err := c.checkSupported(cmdBytes); err != nil {
+		return 0, err
+	}
+	if err := c.lock(ctx); err != nil {
+		return 0, err
 	}
+	defer c.unlock()
+	return c.sendWithAcknowledgementUnlocked(ctx, cmdBytes)
 }
 
-func (c *Controller) SendWithAcknowledgement(cmd encoding.BinaryMarshaler) (int, error) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	return c.sendWithAcknowledgementUnlocked(cmd)
+func (c *Controller) SendAndReceive(ctx context.Context, cmd encoding.BinaryMarshaler, v encoding.BinaryUnmarshaler) error {
+	cmdBytes, err := cmd.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	if err := c.checkSupported(cmdBytes); err != nil {
+		return err
+	}
+	if err := c.lock(ctx); err != nil {
+		return err
+	}
+	defer c.unlock()
+	return c.sendAndReceiveUnlocked(ctx, cmdBytes, v)
 }
 
-func (c *Controller) SendAndReceive(cmd encoding.BinaryMarshaler, v encoding.BinaryUnmarshaler) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	_, err := c.sendWithAcknowledgementUnlocked(cmd)
+func (c *Controller) sendAndReceiveUnlocked(ctx context.Context, cmdBytes []byte, v encoding.BinaryUnmarshaler) error {
+	if len(cmdBytes) < 1 {
+		return fmt.Errorf("cannot send empty command")
+	}
+	id := transport.FunctionID(cmdBytes[0])
+	_, down := c.connection()
+	responses := c.expectResponse(id)
+	defer c.clearResponse()
+	start := time.Now()
+	_, err := c.sendWithAcknowledgementUnlocked(ctx, cmdBytes)
 	if err != nil {
 		return err
 	}
+	t := time.NewTimer(c.Config.Retry.For(id).ResponseTimeout)
+	defer t.Stop()
 	select {
-	case resp := <-c.inbox:
-		err = c.ack()
-		if err != nil {
-			return err
-		}
+	case resp := <-responses:
+		c.metrics.responseLatency.Observe(time.Since(start).Seconds())
 		return v.UnmarshalBinary(resp.Payload)
-	case <-time.After(5 * time.Second):
-		return fmt.Errorf("timed out waiting for response")
+	case <-t.C:
+		return fmt.Errorf("%s: %w", id, ErrResponseTimeout)
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.done:
+		return ErrClosed
+	case <-down:
+		return c.connErr()
 	}
 }
 
-func (c *Controller) SendWithCallback(cmd encoding.BinaryMarshaler, callback func(v encoding.BinaryUnmarshaler)) error {
-	_, err := c.SendWithAcknowledgement(cmd)
+// SendWithCallback sends cmd with a freshly allocated callback ID and calls
+// callback with the callback frames the stick reports for it. By Serial API
+// convention the callback ID is the last byte of the command, which is
+// overwritten. If v is not nil the stick's response is unmarshalled into it
+// before SendWithCallback returns. A timeout of zero means the CallbackTimeout
+// of the retry policy. Cancelling ctx abandons the request and, if it is
+// still pending, the callback, which is then called with ctx.Err().
+func (c *Controller) SendWithCallback(ctx context.Context, cmd encoding.BinaryMarshaler, v encoding.BinaryUnmarshaler, timeout time.Duration, callback Callback) error {
+	cmdBytes, err := cmd.MarshalBinary()
 	if err != nil {
 		return err
 	}
+	if len(cmdBytes) < 2 {
+		return fmt.Errorf("command %x has no room for a callback ID", cmdBytes)
+	}
+	if err := c.checkSupported(cmdBytes); err != nil {
+		return err
+	}
+	if timeout == 0 {
+		timeout = c.Config.Retry.For(transport.FunctionID(cmdBytes[0])).CallbackTimeout
+	}
+	if err := c.lock(ctx); err != nil {
+		return err
+	}
+	defer c.unlock()
+	function := transport.FunctionID(cmdBytes[0])
+	node := nodeFrom(ctx)
+	if node == 0 && function == transport.FuncSendData {
+		node = NodeID(cmdBytes[1])
+	}
+	callback = c.metrics.observeCallback(function, node, callback)
+	id, err := c.callbacks.register(ctx, function, timeout, callback)
+	if err != nil {
+		return err
+	}
+	cmdBytes[len(cmdBytes)-1] = id
+	c.log.Log(logging.Debug, "expecting callback",
+		logging.F("function", function),
+		logging.F("node", node),
+		logging.F("callback", id),
+		logging.F("timeout", timeout))
+
+	if v != nil {
+		err = c.sendAndReceiveUnlocked(ctx, cmdBytes, v)
+	} else {
+		_, err = c.sendWithAcknowledgementUnlocked(ctx, cmdBytes)
+	}
+	if err != nil {
+		c.callbacks.cancel(id)
+	}
 	return err
 }
 
-func (c *Controller) initialize() error {
-	log.Info("Retrieving initialization data...")
+func (c *Controller) initialize(ctx context.Context) error {
+	ctx = WithPriority(ctx, PriorityController)
+	c.log.Log(logging.Info, "retrieving initialization data")
 
-	libraryReport, err := capabilities.NewLibraryVersionGet().Send(c)
+	libraryReport, err := capabilities.NewLibraryVersionGet().SendContext(ctx, c)
 	if err != nil {
 		return err
 	}
-	c.LibraryVersion = libraryReport
+	c.setInfo(func(info *stickInfo) { info.libraryVersion = libraryReport })
 
-	capabilitiesReport, err := capabilities.NewGet().Send(c)
+	capabilitiesReport, err := capabilities.NewGet().SendContext(ctx, c)
 	if err != nil {
 		return err
 	}
-	c.Capabilities = capabilitiesReport
-
-	// req2 := capabilities.NewGetInitData()
-	// res2 := capabilities.InitData{}
-	// if err := c.SendAndReceive(req2, &res2); err != nil {
-	// 	return err
-	// }
-	// c.InitData = res2
-
-	// capabilities, err := capabilities.NewGetCapabilities().Send(c)
-	// if err != nil {
-	// 	return err
-	// }
-	// c.Capabilities = capabilities
-
-	// req4 := capabilities.NewGetControllerCapabilities()
-	// res4 := capabilities.ControllerCapabilities{}
-	// if err := c.SendAndReceive(req4, &res4); err != nil {
-	// 	return err
-	// }
-	// c.ControllerCapabilities = res4
+	c.setInfo(func(info *stickInfo) { info.capabilities = decodeCapabilities(capabilitiesReport) })
+
+	if c.optional(transport.FuncInitData) {
+		initDataReport, err := capabilities.NewInitDataGet().SendContext(ctx, c)
+		if err != nil {
+			return err
+		}
+		c.setInfo(func(info *stickInfo) { info.initData = decodeInitData(initDataReport) })
+	}
+
+	if c.optional(transport.FuncMemoryGetID) {
+		memoryIDReport, err := capabilities.NewMemoryIdGet().SendContext(ctx, c)
+		if err != nil {
+			return err
+		}
+		c.setInfo(func(info *stickInfo) {
+			info.homeID = memoryIDReport.HomeID
+			info.nodeID = NodeID(memoryIDReport.NodeID)
+		})
+	}
+
+	if c.optional(transport.FuncControllerCapabilities) {
+		controllerReport, err := capabilities.NewControllerGet().SendContext(ctx, c)
+		if err != nil {
+			return err
+		}
+		c.setInfo(func(info *stickInfo) {
+			info.controllerCapabilities = decodeControllerCapabilities(controllerReport)
+		})
+	}
 	return nil
 }
 
+// Close stops the controller. Pending requests and callbacks fail with
+// ErrClosed, and Close returns once the controller's goroutines have exited.
 func (c *Controller) Close() error {
-	c.Port.Flush()
-	return c.Port.Close()
+	if c.closeOnce == nil {
+		return nil
+	}
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.connMu.Lock()
+		p, state := c.Port, c.state
+		c.state = Disconnected
+		c.connMu.Unlock()
+		if state != Disconnected {
+			c.flush()
+			err = p.Close()
+			c.emit(Disconnected, ErrClosed)
+		}
+		c.wg.Wait()
+		c.callbacks.closeAll(ErrClosed)
+		c.subscriptions.closeAll()
+	})
+	return err
 }
@@ -1,153 +1,326 @@
 package controller
 
 import (
+	"bytes"
 	"context"
 	"encoding"
-	"fmt"
-	"math/rand"
-	"os"
-	"os/exec"
+	"runtime"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/jbielick/zwgo/capture"
+	"github.com/jbielick/zwgo/emulator"
 	"github.com/jbielick/zwgo/hostapi/capabilities/v0"
+	"github.com/jbielick/zwgo/logging"
+	"github.com/jbielick/zwgo/port"
 	"github.com/jbielick/zwgo/transport"
-	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/tarm/serial"
 )
 
-func init() {
-	log.SetLevel(log.DebugLevel)
+func TestNewConfig(t *testing.T) {
+	config := NewConfig("/dev/test")
+	assert.Equal(t, config.Serial.Name, "/dev/test")
+	assert.Equal(t, config.Serial.Baud, 115200)
+	assert.Equal(t, config.Serial.Size, uint8(8))
+	assert.Equal(t, config.Serial.Parity, serial.ParityNone)
+	assert.Equal(t, config.Serial.ReadTimeout, 10*time.Second)
+	assert.Equal(t, config.FrameTimeout, transport.DefaultFrameTimeout)
 }
 
-func waitForFile(ctx context.Context, path string) {
-	for {
-		_, err := os.Stat(path)
-		if err == nil {
-			return
-		}
-		if os.IsNotExist(err) {
-			time.Sleep(10 * time.Millisecond)
-			continue
+// initRequests is the number of requests Open sends to the emulator: a soft
+// reset and the initialization requests.
+const initRequests = 1 + initializeRequests
+
+// initializeRequests is the number of requests initialize sends: library
+// version, capabilities, init data, memory ID and controller capabilities.
+const initializeRequests = 5
+
+func TestControllerOpen(t *testing.T) {
+	c, stick := openEmulator(t, emulator.DefaultProfile())
+	assert.Equal(t, c.LibraryVersion().Version, "Z-Wave 7.16\x00")
+	assert.Equal(t, c.LibraryVersion().LibraryType, capabilities.LibraryType(capabilities.StaticController))
+	assert.Equal(t, c.Capabilities().Version, byte(0x01))
+	assert.Len(t, stick.Requests(), initRequests)
+	assert.Equal(t, []NodeID{1}, c.InitData().Nodes)
+	assert.Equal(t, byte(0x07), c.InitData().ChipType)
+	assert.Equal(t, uint32(0xC0FFEE00), c.HomeID())
+	assert.Equal(t, NodeID(1), c.NodeID())
+	if assert.NotNil(t, c.Started()) {
+		assert.Equal(t, WakeUpSoftwareReset, c.Started().WakeUpReason)
+	}
+}
+
+func marshal(t *testing.T, v encoding.BinaryMarshaler) []byte {
+	data, err := v.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestControllerOpenReplay(t *testing.T) {
+	exchange := func(req, resp encoding.BinaryMarshaler) []*capture.Record {
+		return []*capture.Record{
+			{Direction: capture.Out, Frame: transport.NewRequest(marshal(t, req))},
+			{Direction: capture.In, Frame: transport.NewACK()},
+			{Direction: capture.In, Frame: transport.NewResponse(marshal(t, resp))},
+			{Direction: capture.Out, Frame: transport.NewACK()},
 		}
 	}
+	supported := make([]byte, 32)
+	supported[0] = 0x02  // INIT_DATA
+	supported[0] |= 0x10 // CONTROLLER_CAPABILITIES
+	supported[3] = 0x80  // MEMORY_GET_ID
+	records := []*capture.Record{
+		{Direction: capture.Out, Frame: transport.NewRequest(marshal(t, capabilities.NewSoftReset()))},
+		{Direction: capture.In, Frame: transport.NewACK()},
+		{Direction: capture.In, Frame: transport.NewRequest([]byte{0x0a, 0x07, 0x00, 0x01, 0x02, 0x01, 0x00})},
+		{Direction: capture.Out, Frame: transport.NewACK()},
+	}
+	records = append(records, exchange(
+		capabilities.NewLibraryVersionGet(),
+		capabilities.LibraryVersionReport{
+			Version:     "Z-Wave 6.07\x00",
+			LibraryType: capabilities.LibraryType(capabilities.StaticController),
+		},
+	)...)
+	records = append(records, exchange(
+		capabilities.NewGet(),
+		capabilities.Report{Version: 0x01, SupportedCommands: supported},
+	)...)
+	records = append(records, exchange(
+		capabilities.NewInitDataGet(),
+		capabilities.InitDataReport{APIVersion: 0x08, NodeListLength: 29, NodeList: make([]byte, 29)},
+	)...)
+	records = append(records, exchange(
+		capabilities.NewMemoryIdGet(),
+		capabilities.MemoryIdReport{HomeID: 0xdeadbeef, NodeID: 0x01},
+	)...)
+	records = append(records, exchange(
+		capabilities.NewControllerGet(),
+		capabilities.ControllerReport{Capabilities: 0x01},
+	)...)
+	replay := capture.NewRecordReplayer(records)
+
+	var recorded bytes.Buffer
+	config := NewConfig("replay")
+	config.Capture = capture.NewWriter(&recorded)
+	config.OpenPort = func(*serial.Config) (port.Port, error) {
+		return replay, nil
+	}
+	c := New(config)
+	assert.NoError(t, c.Open(context.Background()))
+	defer c.Close()
+
+	assert.NoError(t, replay.Err())
+	assert.Equal(t, 0, replay.Remaining())
+	assert.Equal(t, "Z-Wave 6.07\x00", c.LibraryVersion().Version)
+	assert.Equal(t, byte(0x01), c.Capabilities().Version)
+	assert.Equal(t, uint32(0xdeadbeef), c.HomeID())
+	assert.True(t, c.ControllerCapabilities().Secondary)
+
+	got, err := capture.NewReader(&recorded).ReadAll()
+	assert.NoError(t, err)
+	assert.Len(t, got, len(records))
+}
+
+func TestSendAndReceiveSkipsMismatchedResponse(t *testing.T) {
+	stick := emulator.New(emulator.DefaultProfile())
+	stick.Handlers[transport.FuncLibraryVersion] = func(s *emulator.Stick, req *transport.Frame) {
+		s.Respond(transport.FuncMemoryGetID, 0xc0, 0xff, 0xee, 0x00, 0x01)
+		s.Respond(transport.FuncLibraryVersion, append([]byte("Z-Wave 6.07\x00"), 0x07)...)
+	}
+	c := openStick(t, stick)
+
+	assert.Equal(t, "Z-Wave 6.07\x00", c.LibraryVersion().Version)
+	assert.Equal(t, capabilities.LibraryType(capabilities.BridgeController), c.LibraryVersion().LibraryType)
+}
+
+func TestOpenShortReport(t *testing.T) {
+	stick := emulator.New(emulator.DefaultProfile())
+	stick.Handlers[transport.FuncMemoryGetID] = func(s *emulator.Stick, req *transport.Frame) {
+		s.Respond(transport.FuncMemoryGetID, 0xc0, 0xff)
+	}
+	config := NewConfig("emulator")
+	config.OpenPort = stick.Open
+	c := New(config)
+	err := c.Open(context.Background())
+	assert.ErrorContains(t, err, "MEMORY_ID_REPORT: short report (3 bytes)")
+	assert.Equal(t, Disconnected, c.State())
+}
+
+// unansweredFunction is a function the stick reports it supports, and
+// acknowledges, but never answers.
+const unansweredFunction = 0xee
+
+// silentProfile is the default profile with unansweredFunction and
+// REQUEST_NODE_NEIGHBOR_UPDATE, which the emulator does not answer either,
+// among the supported functions.
+func silentProfile() emulator.Profile {
+	p := emulator.DefaultProfile()
+	p.Functions = append(p.Functions, unansweredFunction, transport.FuncRequestNodeNeighborUpdate)
+	return p
 }
 
-type StubbedExchange struct {
-	Request   encoding.BinaryMarshaler
-	Responses []encoding.BinaryMarshaler
+// openEmulator opens a controller on an emulated stick with profile, after
+// applying configure to its config. The controller is closed when the test
+// ends.
+func openEmulator(t *testing.T, profile emulator.Profile, configure ...func(*Config)) (*Controller, *emulator.Stick) {
+	t.Helper()
+	stick := emulator.New(profile)
+	return openStick(t, stick, configure...), stick
 }
 
-func stubbedServer(t *testing.T, f func(Config, chan StubbedExchange)) {
-	responses := make(chan StubbedExchange, 10)
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// openStick is openEmulator for a stick the test has already set up.
+func openStick(t *testing.T, stick *emulator.Stick, configure ...func(*Config)) *Controller {
+	t.Helper()
+	config := NewConfig("emulator")
+	config.OpenPort = stick.Open
+	for _, fn := range configure {
+		fn(&config)
+	}
+	c := New(config)
+	t.Cleanup(func() { c.Close() })
+	if err := c.Open(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestSendAndReceiveCancelled(t *testing.T) {
+	c, _ := openEmulator(t, silentProfile())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
 	defer cancel()
-	i := rand.Intn(50) + 100
-	linkServer := fmt.Sprintf("/tmp/zwgos.%d", i)
-	linkClient := fmt.Sprintf("/tmp/zwgoc.%d", i)
-	cmd := exec.CommandContext(
-		ctx,
-		"socat",
-		"-d",
-		"-d",
-		fmt.Sprintf("pty,link=%s,raw,echo=0", linkServer),
-		fmt.Sprintf("pty,link=%s,raw,echo=0", linkClient),
-	)
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
-	ready := make(chan bool)
+	var resp rawReport
+	err := c.SendAndReceive(ctx, rawCommand{unansweredFunction}, &resp)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// the port is free again
+	report, err := capabilities.NewLibraryVersionGet().SendContext(context.Background(), c)
+	assert.NoError(t, err)
+	assert.Equal(t, "Z-Wave 7.16\x00", report.Version)
+}
+
+func TestSendWaitsForPortUntilCancelled(t *testing.T) {
+	c, stick := openEmulator(t, silentProfile())
+
+	// an unanswered request holds the port until it is cancelled
+	busy, release := context.WithCancel(context.Background())
+	inflight := make(chan error, 1)
 	go func() {
-		if err := cmd.Start(); err != nil {
-			log.Fatal(err)
-		}
-		waitForFile(ctx, linkServer)
-		waitForFile(ctx, linkClient)
+		var resp rawReport
+		inflight <- c.SendAndReceive(busy, rawCommand{unansweredFunction}, &resp)
+	}()
+	for len(stick.Requests()) < initRequests+1 {
+		time.Sleep(time.Millisecond)
+	}
 
-		serverSocket, err := os.OpenFile(linkServer, os.O_RDWR, os.ModePerm)
-		if err != nil {
-			log.Fatal(err)
-		}
-		ready <- true
-		decoder := transport.NewDecoder(serverSocket)
-		for {
-			frame, err := decoder.Next()
-			if err != nil {
-				t.Error(err)
-				return
-			}
-			if frame.IsACK() {
-				continue
-			} else if frame.IsDataFrame() {
-				resp := <-responses
-				reqBytes, err := resp.Request.MarshalBinary()
-				if err != nil {
-					t.Error(err)
-					return
-				}
-				assert.EqualValues(t, reqBytes, frame.Payload)
-				for _, payload := range resp.Responses {
-					payloadBytes, err := payload.MarshalBinary()
-					if err != nil {
-						t.Error(err)
-						return
-					}
-					resp := transport.NewResponse(payloadBytes)
-					respBytes, err := resp.MarshalBinary()
-					if err != nil {
-						t.Error(err)
-						return
-					}
-					_, err = serverSocket.Write(respBytes)
-					if err != nil {
-						t.Error(err)
-						return
-					}
-				}
-			} else {
-				t.Errorf("received unexpected frame: %v", frame)
-			}
-		}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := c.SendWithAcknowledgement(ctx, rawCommand{0x15})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Len(t, stick.Requests(), initRequests+1, "the cancelled request never reached the stick")
+
+	release()
+	assert.ErrorIs(t, <-inflight, context.Canceled)
+}
+
+func TestCloseFailsPendingRequests(t *testing.T) {
+	before := runtime.NumGoroutine()
+	c, stick := openEmulator(t, silentProfile())
+
+	callbackErrs := make(chan error, 1)
+	err := c.SendWithCallback(context.Background(), rawCommand{0x48, 0x02, 0x00}, nil, time.Minute, func(f *transport.Frame, err error) bool {
+		callbackErrs <- err
+		return true
+	})
+	assert.NoError(t, err)
+
+	requestErrs := make(chan error, 1)
+	go func() {
+		var resp rawReport
+		requestErrs <- c.SendAndReceive(context.Background(), rawCommand{unansweredFunction}, &resp)
 	}()
-	<-ready
-	f(NewConfig(linkClient), responses)
-	cmd.Process.Kill()
+	for len(stick.Requests()) < initRequests+2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	assert.NoError(t, c.Close())
+	assert.ErrorIs(t, <-requestErrs, ErrClosed)
+	assert.ErrorIs(t, <-callbackErrs, ErrClosed)
+	_, err = c.SendWithAcknowledgement(context.Background(), rawCommand{0x15})
+	assert.ErrorIs(t, err, ErrClosed)
+	assert.NoError(t, c.Close(), "closing twice is harmless")
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before)
 }
 
-func TestNewConfig(t *testing.T) {
-	config := NewConfig("/dev/test")
-	assert.Equal(t, config.Serial.Name, "/dev/test")
-	assert.Equal(t, config.Serial.Baud, 115200)
-	assert.Equal(t, config.Serial.Size, uint8(8))
-	assert.Equal(t, config.Serial.Parity, serial.ParityNone)
-	assert.Equal(t, config.Serial.ReadTimeout, 10*time.Second)
+type logRecorder struct {
+	mu       sync.Mutex
+	messages map[string][]logging.Field
 }
 
-func TestControllerOpen(t *testing.T) {
-	stubbedServer(t, func(config Config, responses chan StubbedExchange) {
-		c := New(config)
-		responses <- StubbedExchange{
-			Request: capabilities.NewLibraryVersionGet(),
-			Responses: []encoding.BinaryMarshaler{
-				transport.NewACK(),
-				capabilities.LibraryVersionReport{
-					Version:     "Z-Wave 6.07\x00",
-					LibraryType: capabilities.LibraryType(capabilities.StaticController),
-				},
-			},
-		}
-		responses <- StubbedExchange{
-			Request: capabilities.NewGet(),
-			Responses: []encoding.BinaryMarshaler{
-				transport.NewACK(),
-				capabilities.Report{},
-			},
-		}
-		err := c.Open()
-		if err != nil {
-			t.Error(err)
-		}
-		assert.Equal(t, c.LibraryVersion.Version, "Z-Wave 6.07\x00")
-		assert.Equal(t, c.Capabilities.Version, 0x01)
+func (r *logRecorder) Log(level logging.Level, msg string, fields ...logging.Field) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages[msg] = fields
+}
+
+func TestLogger(t *testing.T) {
+	logs := &logRecorder{messages: make(map[string][]logging.Field)}
+	openEmulator(t, emulator.DefaultProfile(), func(config *Config) {
+		config.Logger = logs
+	})
+
+	logs.mu.Lock()
+	defer logs.mu.Unlock()
+	assert.Contains(t, logs.messages, "retrieving initialization data")
+	fields := logs.messages["sent frame"]
+	assert.Contains(t, fields, logging.F(logging.SubsystemKey, "controller"))
+	assert.Contains(t, fields, logging.F("direction", capture.Out))
+}
+
+type countingRecorder struct {
+	mu  sync.Mutex
+	out int
+}
+
+func (r *countingRecorder) Write(dir capture.Direction, f *transport.Frame) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if dir == capture.Out {
+		r.out++
+	}
+	return nil
+}
+
+func (r *countingRecorder) sent() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.out
+}
+
+func TestSendCapturesOnlyWrittenFrames(t *testing.T) {
+	recorder := &countingRecorder{}
+	c, stick := openEmulator(t, emulator.DefaultProfile(), func(config *Config) {
+		config.Capture = recorder
+		config.ReconnectBackoff = 0
 	})
+	sent := recorder.sent()
+	assert.NotZero(t, sent)
+
+	stick.Unplug()
+	for c.State() != Disconnected {
+		time.Sleep(time.Millisecond)
+	}
+	_, err := c.Send(transport.NewACK())
+	assert.Error(t, err)
+	assert.Equal(t, sent, recorder.sent(), "a frame that was not written is not captured")
 }
@@ -0,0 +1,88 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jbielick/zwgo/transport"
+)
+
+var (
+	// ErrClosed is returned for requests that were pending or made after the
+	// controller was closed.
+	ErrClosed = errors.New("controller closed")
+	// ErrDisconnected is returned for requests that were pending or made
+	// while the stick was disconnected.
+	ErrDisconnected = errors.New("controller disconnected")
+	// ErrNAK means the stick rejected a frame as corrupt.
+	ErrNAK = errors.New("frame not acknowledged (NAK)")
+	// ErrCAN means the stick dropped a frame because it was sending one
+	// itself.
+	ErrCAN = errors.New("frame cancelled (CAN)")
+	// ErrAckTimeout means the stick did not acknowledge a frame in time.
+	ErrAckTimeout = errors.New("timed out waiting for acknowledgement")
+	// ErrResponseTimeout means the stick acknowledged a request but did not
+	// answer it in time.
+	ErrResponseTimeout = errors.New("timed out waiting for response")
+	// ErrCallbackTimeout means the stick did not report back on a request
+	// sent with SendWithCallback in time.
+	ErrCallbackTimeout = errors.New("timed out waiting for callback")
+	// ErrUnsupported is returned for requests of functions the stick
+	// reported it does not implement.
+	ErrUnsupported = errors.New("unsupported by this stick firmware")
+	// ErrNotPrimary is returned for network management requests, such as
+	// inclusion, made to a secondary controller.
+	ErrNotPrimary = errors.New("requires a primary controller, but the stick is secondary")
+)
+
+// TransmitStatus is the outcome of a transmission the stick reports in a
+// callback, such as the one for SendData.
+type TransmitStatus byte
+
+const (
+	TransmitOK             TransmitStatus = 0x00
+	TransmitNoAck          TransmitStatus = 0x01
+	TransmitFail           TransmitStatus = 0x02
+	TransmitRoutingNotIdle TransmitStatus = 0x03
+	TransmitNoRoute        TransmitStatus = 0x04
+	TransmitVerified       TransmitStatus = 0x05
+)
+
+var transmitStatusNames = map[TransmitStatus]string{
+	TransmitOK:             "ok",
+	TransmitNoAck:          "no ack",
+	TransmitFail:           "fail",
+	TransmitRoutingNotIdle: "routing not idle",
+	TransmitNoRoute:        "no route",
+	TransmitVerified:       "verified",
+}
+
+func (s TransmitStatus) String() string {
+	if name, ok := transmitStatusNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("TransmitStatus(0x%02x)", byte(s))
+}
+
+// TransmitError reports a transmission the stick could not complete.
+type TransmitError struct {
+	Status TransmitStatus
+}
+
+func (e *TransmitError) Error() string {
+	return fmt.Sprintf("transmission failed: %s", e.Status)
+}
+
+// CheckTransmit returns a *TransmitError if the transmission callback f, laid
+// out as function ID, callback ID and status, reports a failure.
+func CheckTransmit(f *transport.Frame) error {
+	if len(f.Payload) < 3 {
+		return fmt.Errorf("callback %s has no transmit status", f)
+	}
+	switch status := TransmitStatus(f.Payload[2]); status {
+	case TransmitOK, TransmitVerified:
+		return nil
+	default:
+		return &TransmitError{Status: status}
+	}
+}
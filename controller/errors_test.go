@@ -0,0 +1,41 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jbielick/zwgo/capture"
+	"github.com/jbielick/zwgo/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckTransmit(t *testing.T) {
+	assert.NoError(t, CheckTransmit(transport.NewRequest([]byte{0x13, 0x01, 0x00})))
+	assert.NoError(t, CheckTransmit(transport.NewRequest([]byte{0x13, 0x01, 0x05})))
+
+	err := fmt.Errorf("node 3: %w", CheckTransmit(transport.NewRequest([]byte{0x13, 0x01, 0x01, 0x00, 0x12})))
+	var transmit *TransmitError
+	if assert.True(t, errors.As(err, &transmit)) {
+		assert.Equal(t, TransmitNoAck, transmit.Status)
+	}
+	assert.EqualError(t, err, "node 3: transmission failed: no ack")
+
+	assert.Error(t, CheckTransmit(transport.NewRequest([]byte{0x13, 0x01})))
+}
+
+func TestResponseTimeout(t *testing.T) {
+	policy := DefaultRetryPolicy()
+	policy.ResponseTimeout = 10 * time.Millisecond
+	c, _ := connectReplay(t, policy, []*capture.Record{
+		{Direction: capture.Out, Frame: transport.NewRequest([]byte{0x15})},
+		{Direction: capture.In, Frame: transport.NewACK()},
+	})
+
+	var resp rawReport
+	err := c.SendAndReceive(context.Background(), rawCommand{0x15}, &resp)
+	assert.ErrorIs(t, err, ErrResponseTimeout)
+	assert.EqualError(t, err, "LIBRARY_VERSION: timed out waiting for response")
+}
@@ -0,0 +1,277 @@
+package controller
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/jbielick/zwgo/transport"
+)
+
+// DefaultSubscriptionBuffer is the number of events buffered for a
+// subscriber when Subscribe is given no buffer size.
+const DefaultSubscriptionBuffer = 16
+
+// NodeID identifies a node in the Z-Wave network.
+type NodeID byte
+
+// An Event is an unsolicited request frame from the stick. Frames the
+// controller knows are decoded into ApplicationCommand, ApplicationUpdate or
+// SerialAPIStarted; any other function is delivered as a FrameEvent.
+type Event interface {
+	Function() transport.FunctionID
+	Frame() *transport.Frame
+}
+
+// FrameEvent is an unsolicited request frame that is not decoded further.
+type FrameEvent struct {
+	frame *transport.Frame
+}
+
+func (e *FrameEvent) Function() transport.FunctionID {
+	id, _ := e.frame.FunctionID()
+	return id
+}
+
+func (e *FrameEvent) Frame() *transport.Frame {
+	return e.frame
+}
+
+// ApplicationCommand is a command class command received from a node, from
+// APPLICATION_COMMAND_HANDLER or its bridge variant.
+type ApplicationCommand struct {
+	FrameEvent
+	// Status is the receive status byte.
+	Status byte
+	Source NodeID
+	// Destination is the virtual node addressed; only set by bridge
+	// controllers.
+	Destination  NodeID
+	CommandClass byte
+	// Command holds the command class, the command and its parameters.
+	Command []byte
+}
+
+// ApplicationUpdate reports a node information frame or a change in the
+// network, from APPLICATION_UPDATE.
+type ApplicationUpdate struct {
+	FrameEvent
+	Status byte
+	Node   NodeID
+	// Info is the node information: basic, generic and specific device
+	// class followed by supported command classes.
+	Info []byte
+}
+
+// decodeEvent decodes an unsolicited request frame. Frames that are too short
+// for their function are delivered as a FrameEvent.
+func decodeEvent(f *transport.Frame) Event {
+	id, _ := f.FunctionID()
+	p := f.Payload
+	base := FrameEvent{frame: f}
+	switch id {
+	case transport.FuncApplicationCommandHandler:
+		if len(p) < 4 || len(p) < 4+int(p[3]) || p[3] == 0 {
+			break
+		}
+		cmd := p[4 : 4+int(p[3])]
+		return &ApplicationCommand{FrameEvent: base, Status: p[1], Source: NodeID(p[2]), CommandClass: cmd[0], Command: cmd}
+	case transport.FuncApplicationCommandHandlerBridge:
+		if len(p) < 5 || len(p) < 5+int(p[4]) || p[4] == 0 {
+			break
+		}
+		cmd := p[5 : 5+int(p[4])]
+		return &ApplicationCommand{FrameEvent: base, Status: p[1], Destination: NodeID(p[2]), Source: NodeID(p[3]), CommandClass: cmd[0], Command: cmd}
+	case transport.FuncApplicationUpdate:
+		if len(p) < 4 || len(p) < 4+int(p[3]) {
+			break
+		}
+		return &ApplicationUpdate{FrameEvent: base, Status: p[1], Node: NodeID(p[2]), Info: p[4 : 4+int(p[3])]}
+	case transport.FuncSerialAPIStarted:
+		if started, ok := decodeSerialAPIStarted(base, p); ok {
+			return started
+		}
+	}
+	return &base
+}
+
+// Filter selects the events a subscriber receives. Each non-empty field must
+// match; an empty Filter matches every event. Nodes matches the source of
+// application commands and the node of application updates. CommandClasses
+// only matches application commands.
+type Filter struct {
+	Functions      []transport.FunctionID
+	Nodes          []NodeID
+	CommandClasses []byte
+}
+
+func (f Filter) match(e Event) bool {
+	if len(f.Functions) > 0 && !containsFunction(f.Functions, e.Function()) {
+		return false
+	}
+	if len(f.Nodes) > 0 {
+		var node NodeID
+		switch e := e.(type) {
+		case *ApplicationCommand:
+			node = e.Source
+		case *ApplicationUpdate:
+			node = e.Node
+		default:
+			return false
+		}
+		if !containsNode(f.Nodes, node) {
+			return false
+		}
+	}
+	if len(f.CommandClasses) > 0 {
+		cmd, ok := e.(*ApplicationCommand)
+		if !ok || !containsByte(f.CommandClasses, cmd.CommandClass) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsFunction(ids []transport.FunctionID, id transport.FunctionID) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+func containsNode(nodes []NodeID, node NodeID) bool {
+	for _, v := range nodes {
+		if v == node {
+			return true
+		}
+	}
+	return false
+}
+
+func containsByte(b []byte, c byte) bool {
+	for _, v := range b {
+		if v == c {
+			return true
+		}
+	}
+	return false
+}
+
+// A Subscription delivers the events matching its filter on C. Events are
+// dropped, and counted by Dropped, while its buffer is full, so a slow
+// subscriber never holds up the controller. C is closed when the
+// subscription or the controller is closed.
+type Subscription struct {
+	dropped uint64 // first for 64-bit alignment of atomic access
+
+	C <-chan Event
+
+	c      chan Event
+	filter Filter
+	subs   *subscriptions
+}
+
+// Dropped returns the number of events dropped because the buffer was full.
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close stops delivery and closes C.
+func (s *Subscription) Close() {
+	s.subs.remove(s)
+}
+
+// subscriptions is the set of subscribers events are published to.
+type subscriptions struct {
+	mu     sync.Mutex
+	subs   map[*Subscription]struct{}
+	closed bool
+}
+
+func newSubscriptions() *subscriptions {
+	return &subscriptions{subs: make(map[*Subscription]struct{})}
+}
+
+func (s *subscriptions) add(filter Filter, buffer int) *Subscription {
+	if buffer <= 0 {
+		buffer = DefaultSubscriptionBuffer
+	}
+	ch := make(chan Event, buffer)
+	sub := &Subscription{C: ch, c: ch, filter: filter, subs: s}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		close(ch)
+		return sub
+	}
+	s.subs[sub] = struct{}{}
+	return sub
+}
+
+func (s *subscriptions) remove(sub *Subscription) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.subs[sub]; ok {
+		delete(s.subs, sub)
+		close(sub.c)
+	}
+}
+
+// publish hands e to every matching subscriber without blocking and reports
+// whether any subscriber matched.
+func (s *subscriptions) publish(e Event) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	matched := false
+	for sub := range s.subs {
+		if !sub.filter.match(e) {
+			continue
+		}
+		matched = true
+		select {
+		case sub.c <- e:
+		default:
+			atomic.AddUint64(&sub.dropped, 1)
+		}
+	}
+	return matched
+}
+
+// reopen accepts subscriptions again after closeAll.
+func (s *subscriptions) reopen() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = false
+}
+
+func (s *subscriptions) closeAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for sub := range s.subs {
+		delete(s.subs, sub)
+		close(sub.c)
+	}
+}
+
+// Subscribe returns a subscription to the unsolicited requests matching
+// filter, such as application commands and updates. Callback frames for
+// requests sent with SendWithCallback go to their callback instead. buffer is
+// the number of events held for the subscriber; zero means
+// DefaultSubscriptionBuffer.
+func (c *Controller) Subscribe(filter Filter, buffer int) *Subscription {
+	return c.subscriptions.add(filter, buffer)
+}
+
+// SubscribeFunc calls fn with each event matching filter, in order, from a
+// goroutine of its own until the subscription is closed.
+func (c *Controller) SubscribeFunc(filter Filter, buffer int, fn func(Event)) *Subscription {
+	sub := c.Subscribe(filter, buffer)
+	go func() {
+		for e := range sub.C {
+			fn(e)
+		}
+	}()
+	return sub
+}
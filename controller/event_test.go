@@ -0,0 +1,124 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jbielick/zwgo/emulator"
+	"github.com/jbielick/zwgo/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeEvent(t *testing.T) {
+	e := decodeEvent(transport.NewRequest([]byte{0x04, 0x00, 0x03, 0x03, 0x25, 0x03, 0xff}))
+	if assert.IsType(t, &ApplicationCommand{}, e) {
+		cmd := e.(*ApplicationCommand)
+		assert.Equal(t, NodeID(3), cmd.Source)
+		assert.Equal(t, byte(0x25), cmd.CommandClass)
+		assert.Equal(t, []byte{0x25, 0x03, 0xff}, cmd.Command)
+		assert.Equal(t, transport.FuncApplicationCommandHandler, cmd.Function())
+	}
+
+	e = decodeEvent(transport.NewRequest([]byte{0xa8, 0x00, 0x02, 0x05, 0x02, 0x20, 0x01}))
+	if assert.IsType(t, &ApplicationCommand{}, e) {
+		cmd := e.(*ApplicationCommand)
+		assert.Equal(t, NodeID(2), cmd.Destination)
+		assert.Equal(t, NodeID(5), cmd.Source)
+		assert.Equal(t, []byte{0x20, 0x01}, cmd.Command)
+	}
+
+	e = decodeEvent(transport.NewRequest([]byte{0x49, 0x84, 0x07, 0x04, 0x04, 0x10, 0x01, 0x25}))
+	if assert.IsType(t, &ApplicationUpdate{}, e) {
+		update := e.(*ApplicationUpdate)
+		assert.Equal(t, byte(0x84), update.Status)
+		assert.Equal(t, NodeID(7), update.Node)
+		assert.Equal(t, []byte{0x04, 0x10, 0x01, 0x25}, update.Info)
+	}
+
+	// truncated command length
+	e = decodeEvent(transport.NewRequest([]byte{0x04, 0x00, 0x03, 0x05, 0x25}))
+	assert.IsType(t, &FrameEvent{}, e)
+	assert.Equal(t, transport.FuncApplicationCommandHandler, e.Function())
+}
+
+func TestFilterMatch(t *testing.T) {
+	cmd := decodeEvent(transport.NewRequest([]byte{0x04, 0x00, 0x03, 0x02, 0x25, 0x03}))
+	update := decodeEvent(transport.NewRequest([]byte{0x49, 0x84, 0x07, 0x00}))
+	other := decodeEvent(transport.NewRequest([]byte{0x0a, 0x00}))
+
+	assert.True(t, Filter{}.match(cmd))
+	assert.True(t, Filter{}.match(other))
+	assert.True(t, Filter{Functions: []transport.FunctionID{transport.FuncApplicationUpdate}}.match(update))
+	assert.False(t, Filter{Functions: []transport.FunctionID{transport.FuncApplicationUpdate}}.match(cmd))
+	assert.True(t, Filter{Nodes: []NodeID{3, 7}}.match(cmd))
+	assert.True(t, Filter{Nodes: []NodeID{3, 7}}.match(update))
+	assert.False(t, Filter{Nodes: []NodeID{3}}.match(update))
+	assert.False(t, Filter{Nodes: []NodeID{3}}.match(other))
+	assert.True(t, Filter{CommandClasses: []byte{0x25}}.match(cmd))
+	assert.False(t, Filter{CommandClasses: []byte{0x25}}.match(update))
+	assert.False(t, Filter{Nodes: []NodeID{3}, CommandClasses: []byte{0x26}}.match(cmd))
+}
+
+func nextEvent(t *testing.T, sub *Subscription) Event {
+	t.Helper()
+	select {
+	case e := <-sub.C:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an event")
+		return nil
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	c, stick := openEmulator(t, emulator.DefaultProfile())
+
+	switches := c.Subscribe(Filter{CommandClasses: []byte{0x25}}, 0)
+	node4 := c.Subscribe(Filter{Nodes: []NodeID{4}}, 0)
+	funcs := make(chan Event, 4)
+	all := c.SubscribeFunc(Filter{}, 0, func(e Event) { funcs <- e })
+	defer all.Close()
+
+	assert.NoError(t, stick.ApplicationCommand(3, []byte{0x25, 0x03, 0xff}))
+	assert.NoError(t, stick.ApplicationCommand(4, []byte{0x20, 0x03, 0x00}))
+
+	e := nextEvent(t, switches)
+	assert.Equal(t, NodeID(3), e.(*ApplicationCommand).Source)
+	e = nextEvent(t, node4)
+	assert.Equal(t, byte(0x20), e.(*ApplicationCommand).CommandClass)
+	assert.Equal(t, NodeID(3), (<-funcs).(*ApplicationCommand).Source)
+	assert.Equal(t, NodeID(4), (<-funcs).(*ApplicationCommand).Source)
+
+	switches.Close()
+	_, ok := <-switches.C
+	assert.False(t, ok, "closing a subscription closes its channel")
+
+	assert.NoError(t, c.Close())
+	_, ok = <-node4.C
+	assert.False(t, ok, "closing the controller closes subscriptions")
+}
+
+func TestSubscribeAfterReopen(t *testing.T) {
+	c, stick := openEmulator(t, emulator.DefaultProfile())
+	assert.NoError(t, c.Close())
+	assert.NoError(t, c.Open(context.Background()))
+
+	events := c.Subscribe(Filter{}, 0)
+	assert.NoError(t, stick.ApplicationCommand(3, []byte{0x25, 0x03, 0xff}))
+	e := nextEvent(t, events)
+	assert.Equal(t, NodeID(3), e.(*ApplicationCommand).Source)
+}
+
+func TestSlowSubscriberDropsEvents(t *testing.T) {
+	subs := newSubscriptions()
+	slow := subs.add(Filter{}, 1)
+	fast := subs.add(Filter{}, 3)
+	for i := 0; i < 3; i++ {
+		assert.True(t, subs.publish(decodeEvent(transport.NewRequest([]byte{0x0a, byte(i)}))))
+	}
+	assert.Equal(t, uint64(2), slow.Dropped())
+	assert.Equal(t, uint64(0), fast.Dropped())
+	assert.Equal(t, byte(0), (<-slow.C).Frame().Payload[1], "the oldest event is kept")
+	assert.Len(t, fast.C, 3)
+}
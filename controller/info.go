@@ -0,0 +1,69 @@
+package controller
+
+import "github.com/jbielick/zwgo/hostapi/capabilities/v0"
+
+// stickInfo is what the stick reported about itself. It is replaced piece by
+// piece whenever the stick is initialized again, so it is read through the
+// Controller's accessors.
+type stickInfo struct {
+	libraryVersion         capabilities.LibraryVersionReport
+	capabilities           Capabilities
+	controllerCapabilities ControllerCapabilities
+	initData               InitData
+	homeID                 uint32
+	nodeID                 NodeID
+	started                *SerialAPIStarted
+}
+
+func (c *Controller) stickInfo() stickInfo {
+	c.infoMu.RLock()
+	defer c.infoMu.RUnlock()
+	return c.info
+}
+
+// setInfo changes the stick's information with fn.
+func (c *Controller) setInfo(fn func(info *stickInfo)) {
+	c.infoMu.Lock()
+	defer c.infoMu.Unlock()
+	fn(&c.info)
+}
+
+// LibraryVersion returns the version and type of the stick's Z-Wave library.
+func (c *Controller) LibraryVersion() capabilities.LibraryVersionReport {
+	return c.stickInfo().libraryVersion
+}
+
+// Capabilities returns the stick's Serial API version, its product and the
+// functions it implements. Requests of other functions fail with
+// ErrUnsupported.
+func (c *Controller) Capabilities() Capabilities {
+	return c.stickInfo().capabilities
+}
+
+// ControllerCapabilities returns the stick's role in its network. Requests
+// that need a primary controller fail with ErrNotPrimary on a secondary one.
+func (c *Controller) ControllerCapabilities() ControllerCapabilities {
+	return c.stickInfo().controllerCapabilities
+}
+
+// InitData returns what the stick reported about itself and its network
+// when it was last initialized.
+func (c *Controller) InitData() InitData {
+	return c.stickInfo().initData
+}
+
+// HomeID returns the ID of the stick's network.
+func (c *Controller) HomeID() uint32 {
+	return c.stickInfo().homeID
+}
+
+// NodeID returns the stick's node in its network.
+func (c *Controller) NodeID() NodeID {
+	return c.stickInfo().nodeID
+}
+
+// Started returns what the stick reported when its Serial API last started,
+// or nil if it never did.
+func (c *Controller) Started() *SerialAPIStarted {
+	return c.stickInfo().started
+}
@@ -0,0 +1,70 @@
+package controller
+
+import "github.com/jbielick/zwgo/hostapi/capabilities/v0"
+
+// Capability bits of INIT_DATA_REPORT.
+const (
+	initDataEndNode   = 0x01
+	initDataTimers    = 0x02
+	initDataSecondary = 0x04
+	initDataSIS       = 0x08
+)
+
+// InitData is the stick's answer to INIT_DATA_GET.
+type InitData struct {
+	APIVersion byte
+	// EndNode means the stick runs an end node library rather than a
+	// controller library.
+	EndNode bool
+	// Timers means the stick supports the Serial API timer functions.
+	Timers bool
+	// Secondary means the stick is a secondary controller in its network.
+	Secondary bool
+	// SIS means the stick is the network's SUC ID server.
+	SIS bool
+	// Nodes are the nodes in the stick's network, in ascending order.
+	Nodes       []NodeID
+	ChipType    byte
+	ChipVersion byte
+}
+
+// HasNode reports whether node is in the stick's network.
+func (d InitData) HasNode(node NodeID) bool {
+	for _, n := range d.Nodes {
+		if n == node {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeInitData(r capabilities.InitDataReport) InitData {
+	mask := r.NodeList
+	if int(r.NodeListLength) < len(mask) {
+		mask = mask[:r.NodeListLength]
+	}
+	return InitData{
+		APIVersion:  r.APIVersion,
+		EndNode:     r.APICapabilities&initDataEndNode != 0,
+		Timers:      r.APICapabilities&initDataTimers != 0,
+		Secondary:   r.APICapabilities&initDataSecondary != 0,
+		SIS:         r.APICapabilities&initDataSIS != 0,
+		Nodes:       nodesFromBitmask(mask),
+		ChipType:    r.ChipType,
+		ChipVersion: r.ChipVersion,
+	}
+}
+
+// nodesFromBitmask returns the nodes whose bits are set in mask, where bit 0
+// of the first byte is node 1.
+func nodesFromBitmask(mask []byte) []NodeID {
+	var nodes []NodeID
+	for i, b := range mask {
+		for bit := 0; bit < 8; bit++ {
+			if b&(1<<bit) != 0 {
+				nodes = append(nodes, NodeID(i*8+bit+1))
+			}
+		}
+	}
+	return nodes
+}
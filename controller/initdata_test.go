@@ -0,0 +1,41 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/jbielick/zwgo/hostapi/capabilities/v0"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeInitData(t *testing.T) {
+	nodes := make([]byte, 29)
+	nodes[0] = 0x05  // 1, 3
+	nodes[1] = 0x80  // 16
+	nodes[28] = 0x80 // 232
+	data := decodeInitData(capabilities.InitDataReport{
+		APIVersion:      0x08,
+		APICapabilities: 0x0c,
+		NodeListLength:  29,
+		NodeList:        nodes,
+		ChipType:        0x07,
+		ChipVersion:     0x01,
+	})
+	assert.Equal(t, byte(0x08), data.APIVersion)
+	assert.False(t, data.EndNode)
+	assert.False(t, data.Timers)
+	assert.True(t, data.Secondary)
+	assert.True(t, data.SIS)
+	assert.Equal(t, []NodeID{1, 3, 16, 232}, data.Nodes)
+	assert.True(t, data.HasNode(16))
+	assert.False(t, data.HasNode(2))
+	assert.Equal(t, byte(0x07), data.ChipType)
+	assert.Equal(t, byte(0x01), data.ChipVersion)
+}
+
+func TestDecodeInitDataShortList(t *testing.T) {
+	nodes := make([]byte, 29)
+	nodes[0] = 0x01
+	nodes[2] = 0x01
+	data := decodeInitData(capabilities.InitDataReport{NodeListLength: 2, NodeList: nodes})
+	assert.Equal(t, []NodeID{1}, data.Nodes, "bytes past the reported length are ignored")
+}
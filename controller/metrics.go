@@ -0,0 +1,118 @@
+package controller
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jbielick/zwgo/logging"
+	"github.com/jbielick/zwgo/metrics"
+	"github.com/jbielick/zwgo/transport"
+)
+
+var latencyBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// controllerMetrics are the metrics a controller keeps about its traffic.
+type controllerMetrics struct {
+	registry         *metrics.Registry
+	framesIn         *metrics.CounterVec
+	framesOut        *metrics.CounterVec
+	retransmissions  *metrics.CounterVec
+	checksumErrors   *metrics.Counter
+	ackLatency       *metrics.Histogram
+	responseLatency  *metrics.Histogram
+	callbackTimeouts *metrics.Counter
+	transmitFailures *metrics.CounterVec
+}
+
+func newControllerMetrics(q *queue) *controllerMetrics {
+	r := metrics.NewRegistry()
+	m := &controllerMetrics{
+		registry:         r,
+		framesIn:         r.NewCounterVec("zwgo_frames_received_total", "Frames received from the stick.", "type"),
+		framesOut:        r.NewCounterVec("zwgo_frames_sent_total", "Frames sent to the stick.", "type"),
+		retransmissions:  r.NewCounterVec("zwgo_retransmissions_total", "Request frames sent again.", "reason"),
+		checksumErrors:   r.NewCounter("zwgo_checksum_errors_total", "Data frames received with a bad checksum."),
+		ackLatency:       r.NewHistogram("zwgo_ack_latency_seconds", "Time from sending a request frame to its ACK.", latencyBuckets),
+		responseLatency:  r.NewHistogram("zwgo_response_latency_seconds", "Time from sending a request to its response.", latencyBuckets),
+		callbackTimeouts: r.NewCounter("zwgo_callback_timeouts_total", "Callbacks that did not arrive in time."),
+		transmitFailures: r.NewCounterVec("zwgo_transmit_failures_total", "Transmissions to a node the stick reported as failed.", "node"),
+	}
+	r.NewGaugeVecFunc("zwgo_queue_depth", "Requests waiting for the port.", "priority", func() map[string]float64 {
+		stats := q.snapshot()
+		depth := make(map[string]float64, NumPriorities)
+		for i, s := range stats.Priorities {
+			depth[Priority(i).String()] = float64(s.Depth)
+		}
+		return depth
+	})
+	r.NewCounterVecFunc("zwgo_queue_wait_seconds_total", "Time granted requests spent waiting for the port.", "priority", func() map[string]float64 {
+		stats := q.snapshot()
+		wait := make(map[string]float64, NumPriorities)
+		for i, s := range stats.Priorities {
+			wait[Priority(i).String()] = s.TotalWait.Seconds()
+		}
+		return wait
+	})
+	return m
+}
+
+// retransmitReason is the label for a retransmission after err.
+func retransmitReason(err error) string {
+	switch {
+	case errors.Is(err, ErrNAK):
+		return "nak"
+	case errors.Is(err, ErrCAN):
+		return "can"
+	case errors.Is(err, ErrAckTimeout):
+		return "ack_timeout"
+	}
+	return "other"
+}
+
+// observeCallback wraps callback to count callback timeouts and, for
+// SendData, failed transmissions to node.
+func (m *controllerMetrics) observeCallback(function transport.FunctionID, node NodeID, callback Callback) Callback {
+	return func(f *transport.Frame, err error) bool {
+		if errors.Is(err, ErrCallbackTimeout) {
+			m.callbackTimeouts.Inc()
+		}
+		if f != nil && function == transport.FuncSendData && CheckTransmit(f) != nil {
+			m.transmitFailures.With(strconv.Itoa(int(node))).Inc()
+		}
+		return callback(f, err)
+	}
+}
+
+// Metrics returns the registry of the controller's metrics, which can be
+// served over HTTP.
+func (c *Controller) Metrics() *metrics.Registry {
+	return c.metrics.registry
+}
+
+// serveMetrics serves the metrics at /metrics on Config.MetricsAddr until
+// the controller is closed.
+func (c *Controller) serveMetrics() error {
+	l, err := net.Listen("tcp", c.Config.MetricsAddr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", c.metrics.registry)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	c.wg.Add(2)
+	go func() {
+		defer c.wg.Done()
+		if err := server.Serve(l); err != nil && err != http.ErrServerClosed {
+			c.log.Log(logging.Error, "metrics server failed", logging.F("error", err))
+		}
+	}()
+	go func() {
+		defer c.wg.Done()
+		<-c.done
+		server.Close()
+	}()
+	return nil
+}
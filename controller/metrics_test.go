@@ -0,0 +1,68 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jbielick/zwgo/capture"
+	"github.com/jbielick/zwgo/emulator"
+	"github.com/jbielick/zwgo/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+func metricsText(t *testing.T, c *Controller) string {
+	t.Helper()
+	var buf bytes.Buffer
+	assert.NoError(t, c.Metrics().WriteText(&buf))
+	return buf.String()
+}
+
+func TestFrameAndLatencyMetrics(t *testing.T) {
+	c, _ := openEmulator(t, emulator.DefaultProfile())
+
+	text := metricsText(t, c)
+	// every request is acknowledged, and all but the soft reset answered
+	assert.Contains(t, text, fmt.Sprintf(`zwgo_frames_sent_total{type="SOF"} %d`, initRequests))
+	assert.Contains(t, text, fmt.Sprintf(`zwgo_frames_received_total{type="ACK"} %d`, initRequests))
+	assert.Contains(t, text, fmt.Sprintf("zwgo_ack_latency_seconds_count %d", initRequests))
+	assert.Contains(t, text, fmt.Sprintf("zwgo_response_latency_seconds_count %d", initializeRequests))
+	assert.Contains(t, text, `zwgo_queue_depth{priority="user"} 0`)
+	assert.Contains(t, text, "# TYPE zwgo_queue_wait_seconds_total counter")
+}
+
+func TestRetransmissionMetrics(t *testing.T) {
+	request := transport.NewRequest([]byte{0x15})
+	policy := DefaultRetryPolicy()
+	policy.Backoff = func(n int) time.Duration { return time.Millisecond }
+	c, _ := connectReplay(t, policy, []*capture.Record{
+		{Direction: capture.Out, Frame: request},
+		{Direction: capture.In, Frame: transport.NewNAK()},
+		{Direction: capture.Out, Frame: request},
+		{Direction: capture.In, Frame: transport.NewACK()},
+	})
+	_, err := c.SendWithAcknowledgement(context.Background(), rawCommand{0x15})
+	assert.NoError(t, err)
+
+	text := metricsText(t, c)
+	assert.Contains(t, text, `zwgo_retransmissions_total{reason="nak"} 1`)
+	assert.Contains(t, text, `zwgo_frames_received_total{type="NAK"} 1`)
+}
+
+func TestTransmitFailureMetrics(t *testing.T) {
+	profile := emulator.DefaultProfile()
+	profile.TransmitStatus = byte(TransmitNoAck)
+	c, _ := openEmulator(t, profile)
+
+	errs := make(chan error, 1)
+	var resp rawReport
+	err := c.SendWithCallback(context.Background(), rawCommand{0x13, 0x05, 0x02, 0x20, 0x02, 0x25, 0x00}, &resp, time.Second, func(f *transport.Frame, err error) bool {
+		errs <- CheckTransmit(f)
+		return true
+	})
+	assert.NoError(t, err)
+	assert.ErrorAs(t, <-errs, new(*TransmitError))
+	assert.Contains(t, metricsText(t, c), `zwgo_transmit_failures_total{node="5"} 1`)
+}
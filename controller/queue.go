@@ -0,0 +1,239 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Priority orders requests waiting for the port. Lower values go first.
+type Priority int
+
+const (
+	// PriorityController is for managing the stick itself, such as
+	// initialization and inclusion.
+	PriorityController Priority = iota
+	// PriorityUser is for commands a user is waiting on. It is the default.
+	PriorityUser
+	// PriorityPoll is for periodic polling of node state.
+	PriorityPoll
+	// PriorityBackground is for node interviews and other work nobody is
+	// waiting on.
+	PriorityBackground
+
+	NumPriorities = int(PriorityBackground) + 1
+)
+
+var priorityNames = [NumPriorities]string{"controller", "user", "poll", "background"}
+
+func (p Priority) String() string {
+	if p >= 0 && int(p) < NumPriorities {
+		return priorityNames[p]
+	}
+	return fmt.Sprintf("Priority(%d)", int(p))
+}
+
+type priorityKey struct{}
+type nodeKey struct{}
+
+// WithPriority returns a context that queues requests made with it at
+// priority p.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// WithNode returns a context that marks requests made with it as addressed
+// to node. Requests for the same node are sent in order, and nodes of the
+// same priority take turns.
+func WithNode(ctx context.Context, node NodeID) context.Context {
+	return context.WithValue(ctx, nodeKey{}, node)
+}
+
+func priorityFrom(ctx context.Context) Priority {
+	if p, ok := ctx.Value(priorityKey{}).(Priority); ok && p >= 0 && int(p) < NumPriorities {
+		return p
+	}
+	return PriorityUser
+}
+
+func nodeFrom(ctx context.Context) NodeID {
+	node, _ := ctx.Value(nodeKey{}).(NodeID)
+	return node
+}
+
+// PriorityStats describes the requests of one priority.
+type PriorityStats struct {
+	// Depth is the number of requests waiting for the port.
+	Depth int
+	// Granted is the number of requests that got the port, after waiting
+	// TotalWait in all and MaxWait at most.
+	Granted   uint64
+	TotalWait time.Duration
+	MaxWait   time.Duration
+}
+
+// QueueStats describes the transmit queue, indexed by Priority.
+type QueueStats struct {
+	Priorities [NumPriorities]PriorityStats
+}
+
+// Depth returns the number of requests waiting for the port.
+func (s QueueStats) Depth() int {
+	depth := 0
+	for _, p := range s.Priorities {
+		depth += p.Depth
+	}
+	return depth
+}
+
+type waiter struct {
+	priority Priority
+	node     NodeID
+	queued   time.Time
+	ready    chan struct{}
+	granted  bool
+}
+
+// level holds the requests of one priority, in a FIFO per node. Nodes take
+// turns in the order they started waiting.
+type level struct {
+	nodes map[NodeID][]*waiter
+	order []NodeID
+}
+
+func (l *level) push(w *waiter) {
+	if len(l.nodes[w.node]) == 0 {
+		l.order = append(l.order, w.node)
+	}
+	l.nodes[w.node] = append(l.nodes[w.node], w)
+}
+
+func (l *level) pop() *waiter {
+	if len(l.order) == 0 {
+		return nil
+	}
+	node := l.order[0]
+	l.order = l.order[1:]
+	w := l.nodes[node][0]
+	if rest := l.nodes[node][1:]; len(rest) > 0 {
+		l.nodes[node] = rest
+		l.order = append(l.order, node)
+	} else {
+		delete(l.nodes, node)
+	}
+	return w
+}
+
+func (l *level) remove(w *waiter) {
+	waiting := l.nodes[w.node]
+	for i, v := range waiting {
+		if v != w {
+			continue
+		}
+		waiting = append(waiting[:i:i], waiting[i+1:]...)
+		break
+	}
+	if len(waiting) > 0 {
+		l.nodes[w.node] = waiting
+		return
+	}
+	delete(l.nodes, w.node)
+	for i, node := range l.order {
+		if node == w.node {
+			l.order = append(l.order[:i:i], l.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// queue grants exclusive use of the port, one request at a time, to the
+// waiting request of the highest priority.
+type queue struct {
+	mu     sync.Mutex
+	busy   bool
+	levels [NumPriorities]level
+	stats  QueueStats
+}
+
+func newQueue() *queue {
+	q := &queue{}
+	for i := range q.levels {
+		q.levels[i].nodes = make(map[NodeID][]*waiter)
+	}
+	return q
+}
+
+// acquire waits until the port is granted to the caller, ctx is done or done
+// is closed.
+func (q *queue) acquire(ctx context.Context, done <-chan struct{}, priority Priority, node NodeID) error {
+	q.mu.Lock()
+	if !q.busy {
+		q.busy = true
+		q.granted(priority, 0)
+		q.mu.Unlock()
+		return nil
+	}
+	w := &waiter{priority: priority, node: node, queued: time.Now(), ready: make(chan struct{})}
+	q.levels[priority].push(w)
+	q.stats.Priorities[priority].Depth++
+	q.mu.Unlock()
+
+	var err error
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-done:
+		err = ErrClosed
+	}
+
+	q.mu.Lock()
+	if w.granted {
+		q.mu.Unlock()
+		q.release()
+		return err
+	}
+	q.levels[priority].remove(w)
+	q.stats.Priorities[priority].Depth--
+	q.mu.Unlock()
+	return err
+}
+
+// release passes the port on to the next waiting request.
+func (q *queue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i := range q.levels {
+		if w := q.levels[i].pop(); w != nil {
+			q.stats.Priorities[i].Depth--
+			q.granted(w.priority, time.Since(w.queued))
+			w.granted = true
+			close(w.ready)
+			return
+		}
+	}
+	q.busy = false
+}
+
+func (q *queue) granted(priority Priority, wait time.Duration) {
+	s := &q.stats.Priorities[priority]
+	s.Granted++
+	s.TotalWait += wait
+	if wait > s.MaxWait {
+		s.MaxWait = wait
+	}
+}
+
+func (q *queue) snapshot() QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.stats
+}
+
+// QueueStats returns the current depth and the wait times of the transmit
+// queue.
+func (c *Controller) QueueStats() QueueStats {
+	return c.queue.snapshot()
+}
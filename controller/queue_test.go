@@ -0,0 +1,88 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// enqueue starts a request waiting in q that sends name on granted once the
+// port is granted.
+func enqueue(t *testing.T, q *queue, granted chan string, name string, p Priority, node NodeID) {
+	t.Helper()
+	go func() {
+		if err := q.acquire(context.Background(), nil, p, node); err != nil {
+			t.Error(err)
+			return
+		}
+		granted <- name
+	}()
+	// wait until it is queued so the order of enqueue calls is kept
+	depth := q.snapshot().Depth()
+	for q.snapshot().Depth() == depth {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestQueuePriorityAndFairness(t *testing.T) {
+	q := newQueue()
+	assert.NoError(t, q.acquire(context.Background(), nil, PriorityUser, 0))
+
+	granted := make(chan string, 8)
+	enqueue(t, q, granted, "interview 5", PriorityBackground, 5)
+	enqueue(t, q, granted, "poll 2 a", PriorityPoll, 2)
+	enqueue(t, q, granted, "poll 2 b", PriorityPoll, 2)
+	enqueue(t, q, granted, "poll 3", PriorityPoll, 3)
+	enqueue(t, q, granted, "lights off", PriorityUser, 4)
+	enqueue(t, q, granted, "init", PriorityController, 0)
+	assert.Equal(t, 6, q.snapshot().Depth())
+
+	var order []string
+	for i := 0; i < 6; i++ {
+		q.release()
+		order = append(order, <-granted)
+	}
+	assert.Equal(t, []string{"init", "lights off", "poll 2 a", "poll 3", "poll 2 b", "interview 5"}, order)
+
+	q.release()
+	stats := q.snapshot()
+	assert.Equal(t, 0, stats.Depth())
+	assert.Equal(t, uint64(3), stats.Priorities[PriorityPoll].Granted)
+	assert.Equal(t, uint64(2), stats.Priorities[PriorityUser].Granted)
+	assert.True(t, stats.Priorities[PriorityBackground].MaxWait > 0)
+	assert.NoError(t, q.acquire(context.Background(), nil, PriorityUser, 0), "the port is free again")
+}
+
+func TestQueueCancelled(t *testing.T) {
+	q := newQueue()
+	assert.NoError(t, q.acquire(context.Background(), nil, PriorityUser, 0))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() { errs <- q.acquire(ctx, nil, PriorityPoll, 2) }()
+	for q.snapshot().Depth() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	assert.ErrorIs(t, <-errs, context.Canceled)
+	assert.Equal(t, 0, q.snapshot().Depth())
+
+	done := make(chan struct{})
+	go func() { errs <- q.acquire(context.Background(), done, PriorityPoll, 2) }()
+	for q.snapshot().Depth() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	close(done)
+	assert.ErrorIs(t, <-errs, ErrClosed)
+}
+
+func TestPriorityFromContext(t *testing.T) {
+	ctx := context.Background()
+	assert.Equal(t, PriorityUser, priorityFrom(ctx))
+	assert.Equal(t, PriorityPoll, priorityFrom(WithPriority(ctx, PriorityPoll)))
+	assert.Equal(t, NodeID(0), nodeFrom(ctx))
+	assert.Equal(t, NodeID(7), nodeFrom(WithNode(ctx, 7)))
+	assert.Equal(t, "background", PriorityBackground.String())
+}
@@ -0,0 +1,173 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jbielick/zwgo/hostapi/capabilities/v0"
+	"github.com/jbielick/zwgo/logging"
+)
+
+// DefaultStartTimeout is how long Open waits for SERIAL_API_STARTED after a
+// soft reset.
+const DefaultStartTimeout = 2 * time.Second
+
+// WakeUpReason is the reason the stick's Serial API (re)started.
+type WakeUpReason byte
+
+const (
+	WakeUpReset             WakeUpReason = 0x00
+	WakeUpTimer             WakeUpReason = 0x01
+	WakeUpBeam              WakeUpReason = 0x02
+	WakeUpWatchdog          WakeUpReason = 0x03
+	WakeUpExternalInterrupt WakeUpReason = 0x04
+	WakeUpPowerUp           WakeUpReason = 0x05
+	WakeUpUSBSuspend        WakeUpReason = 0x06
+	WakeUpSoftwareReset     WakeUpReason = 0x07
+	WakeUpEmergencyWatchdog WakeUpReason = 0x08
+	WakeUpBrownout          WakeUpReason = 0x09
+	WakeUpUnknown           WakeUpReason = 0xFF
+)
+
+var wakeUpReasonNames = map[WakeUpReason]string{
+	WakeUpReset:             "reset",
+	WakeUpTimer:             "wake-up timer",
+	WakeUpBeam:              "wake-up beam",
+	WakeUpWatchdog:          "watchdog",
+	WakeUpExternalInterrupt: "external interrupt",
+	WakeUpPowerUp:           "power up",
+	WakeUpUSBSuspend:        "USB suspend",
+	WakeUpSoftwareReset:     "software reset",
+	WakeUpEmergencyWatchdog: "emergency watchdog",
+	WakeUpBrownout:          "brownout",
+	WakeUpUnknown:           "unknown",
+}
+
+func (r WakeUpReason) String() string {
+	if name, ok := wakeUpReasonNames[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("WakeUpReason(0x%02x)", byte(r))
+}
+
+// SerialAPIStarted is sent by the stick whenever its Serial API starts, after
+// a soft reset as well as after a crash or power loss.
+type SerialAPIStarted struct {
+	FrameEvent
+	WakeUpReason    WakeUpReason
+	WatchdogStarted bool
+	// DeviceOptions is the device option mask; bit 0 means listening.
+	DeviceOptions byte
+	GenericType   byte
+	SpecificType  byte
+	// CommandClasses are the command classes the stick supports.
+	CommandClasses []byte
+	// LongRange reports support for Z-Wave Long Range. Firmware predating
+	// Long Range omits the field.
+	LongRange bool
+}
+
+func decodeSerialAPIStarted(base FrameEvent, p []byte) (*SerialAPIStarted, bool) {
+	if len(p) < 7 || len(p) < 7+int(p[6]) {
+		return nil, false
+	}
+	n := int(p[6])
+	started := &SerialAPIStarted{
+		FrameEvent:      base,
+		WakeUpReason:    WakeUpReason(p[1]),
+		WatchdogStarted: p[2] != 0,
+		DeviceOptions:   p[3],
+		GenericType:     p[4],
+		SpecificType:    p[5],
+		CommandClasses:  p[7 : 7+n],
+	}
+	if len(p) > 7+n {
+		started.LongRange = p[7+n]&0x01 != 0
+	}
+	return started, true
+}
+
+// start resets the stick if configured to and retrieves its initialization
+// data.
+func (c *Controller) start(ctx context.Context) error {
+	if c.Config.SoftReset {
+		if err := c.softReset(ctx); err != nil {
+			return err
+		}
+	}
+	return c.initialize(ctx)
+}
+
+// softReset restarts the stick's Serial API and waits for it to report
+// SERIAL_API_STARTED. Older firmware never does, so running out of
+// StartTimeout is not an error.
+func (c *Controller) softReset(ctx context.Context) error {
+	started := make(chan *SerialAPIStarted, 1)
+	c.startMu.Lock()
+	c.starting = started
+	c.startMu.Unlock()
+	defer func() {
+		c.startMu.Lock()
+		c.starting = nil
+		c.startMu.Unlock()
+	}()
+
+	c.log.Log(logging.Info, "resetting Serial API")
+	ctx = WithPriority(ctx, PriorityController)
+	reset := capabilities.NewSoftReset()
+	if err := reset.SendContext(ctx, c); err != nil {
+		return err
+	}
+	timeout := c.Config.StartTimeout
+	if timeout == 0 {
+		timeout = DefaultStartTimeout
+	}
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case s := <-started:
+		c.setInfo(func(info *stickInfo) { info.started = s })
+		c.log.Log(logging.Info, "Serial API started", logging.F("reason", s.WakeUpReason))
+	case <-t.C:
+		c.log.Log(logging.Warn, "stick did not report SERIAL_API_STARTED", logging.F("timeout", timeout))
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.done:
+		return ErrClosed
+	}
+	return nil
+}
+
+// handleStarted hands s to a pending soft reset. Otherwise the stick
+// restarted on its own and has lost its state, so the supervisor initializes
+// it again.
+func (c *Controller) handleStarted(s *SerialAPIStarted) {
+	c.startMu.Lock()
+	defer c.startMu.Unlock()
+	if c.starting != nil {
+		select {
+		case c.starting <- s:
+		default:
+		}
+		return
+	}
+	if c.State() != Connected {
+		return
+	}
+	select {
+	case c.restarted <- s:
+	default:
+	}
+}
+
+// reinitialize initializes a stick that restarted unexpectedly. If that
+// fails the connection is treated as lost.
+func (c *Controller) reinitialize(s *SerialAPIStarted) {
+	c.log.Log(logging.Warn, "stick restarted unexpectedly, initializing again", logging.F("reason", s.WakeUpReason))
+	c.setInfo(func(info *stickInfo) { info.started = s })
+	if err := c.initialize(context.Background()); err != nil {
+		_, down := c.connection()
+		c.disconnected(down, err)
+	}
+}
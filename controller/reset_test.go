@@ -0,0 +1,95 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jbielick/zwgo/emulator"
+	"github.com/jbielick/zwgo/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeSerialAPIStarted(t *testing.T) {
+	e := decodeEvent(transport.NewRequest([]byte{0x0a, 0x03, 0x01, 0x01, 0x02, 0x01, 0x02, 0x5e, 0x86, 0x01}))
+	if assert.IsType(t, &SerialAPIStarted{}, e) {
+		started := e.(*SerialAPIStarted)
+		assert.Equal(t, WakeUpWatchdog, started.WakeUpReason)
+		assert.True(t, started.WatchdogStarted)
+		assert.Equal(t, byte(0x02), started.GenericType)
+		assert.Equal(t, []byte{0x5e, 0x86}, started.CommandClasses)
+		assert.True(t, started.LongRange)
+	}
+
+	// firmware without Long Range omits the supported protocols
+	e = decodeEvent(transport.NewRequest([]byte{0x0a, 0x07, 0x00, 0x01, 0x02, 0x01, 0x00}))
+	if assert.IsType(t, &SerialAPIStarted{}, e) {
+		assert.False(t, e.(*SerialAPIStarted).LongRange)
+	}
+
+	assert.Equal(t, "software reset", WakeUpSoftwareReset.String())
+	assert.Equal(t, "WakeUpReason(0x42)", WakeUpReason(0x42).String())
+}
+
+func TestSoftResetWithoutStarted(t *testing.T) {
+	profile := emulator.DefaultProfile()
+	profile.Started = false
+	c, stick := openEmulator(t, profile, func(config *Config) {
+		config.StartTimeout = 20 * time.Millisecond
+	})
+
+	assert.Nil(t, c.Started())
+	assert.Equal(t, "Z-Wave 7.16\x00", c.LibraryVersion().Version)
+	id, _ := stick.Requests()[0].FunctionID()
+	assert.Equal(t, transport.FuncSoftReset, id)
+}
+
+func TestSoftResetDisabled(t *testing.T) {
+	c, stick := openEmulator(t, emulator.DefaultProfile(), func(config *Config) {
+		config.SoftReset = false
+	})
+
+	assert.Len(t, stick.Requests(), initializeRequests)
+	assert.Nil(t, c.Started())
+}
+
+func TestUnexpectedRestart(t *testing.T) {
+	c, stick := openEmulator(t, emulator.DefaultProfile())
+
+	events := c.Subscribe(Filter{Functions: []transport.FunctionID{transport.FuncSerialAPIStarted}}, 0)
+	assert.NoError(t, stick.Restart(emulator.WakeUpWatchdog))
+	e := nextEvent(t, events)
+	assert.Equal(t, WakeUpWatchdog, e.(*SerialAPIStarted).WakeUpReason)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(stick.Requests()) < initRequests+initializeRequests && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Len(t, stick.Requests(), initRequests+initializeRequests, "the stick is initialized again without another reset")
+	assert.Equal(t, Connected, c.State())
+}
+
+func TestStickInfoDuringRestart(t *testing.T) {
+	c, stick := openEmulator(t, emulator.DefaultProfile())
+
+	stop := make(chan struct{})
+	read := make(chan struct{})
+	go func() {
+		defer close(read)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			assert.Equal(t, uint32(0xC0FFEE00), c.HomeID())
+			assert.Equal(t, []NodeID{1}, c.InitData().Nodes)
+			c.Started()
+		}
+	}()
+	assert.NoError(t, stick.Restart(emulator.WakeUpWatchdog))
+	for len(stick.Requests()) < initRequests+initializeRequests {
+		time.Sleep(time.Millisecond)
+	}
+	close(stop)
+	<-read
+}
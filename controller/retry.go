@@ -0,0 +1,116 @@
+package controller
+
+import (
+	"time"
+
+	"github.com/jbielick/zwgo/transport"
+)
+
+// Defaults from the Serial API host specification.
+const (
+	DefaultAckTimeout      = 1600 * time.Millisecond
+	DefaultResponseTimeout = 10 * time.Second
+	DefaultMaxRetransmits  = 3
+	// SendDataCallbackTimeout covers the longest transmission SendData
+	// reports back on.
+	SendDataCallbackTimeout = 65 * time.Second
+)
+
+// DefaultBackoff waits 100ms + n·1s before retransmission n, as the host
+// specification prescribes.
+func DefaultBackoff(n int) time.Duration {
+	return 100*time.Millisecond + time.Duration(n)*time.Second
+}
+
+// RetryPolicy controls how long the controller waits for the stick and how
+// often it retransmits a request frame. Zero values mean the defaults, so the
+// zero RetryPolicy behaves like DefaultRetryPolicy apart from its overrides.
+type RetryPolicy struct {
+	// AckTimeout is how long to wait for the stick to ACK a frame.
+	AckTimeout time.Duration
+	// ResponseTimeout is how long to wait for the response to a request
+	// after the ACK.
+	ResponseTimeout time.Duration
+	// CallbackTimeout is how long SendWithCallback waits for callbacks when
+	// it is given no timeout.
+	CallbackTimeout time.Duration
+	// MaxRetransmits is how often a frame is sent again after a NAK, a CAN
+	// or an ACK timeout. A negative value disables retransmission.
+	MaxRetransmits int
+	// Backoff returns the delay before retransmission n, counting from 1.
+	Backoff func(n int) time.Duration
+	// NoRetryOnCAN gives up on a frame the stick cancelled because it was
+	// sending a frame itself, instead of retransmitting it after CANDelay
+	// and the backoff.
+	NoRetryOnCAN bool
+	CANDelay     time.Duration
+	// Overrides changes the policy for requests of the given functions. Only
+	// the non-zero fields of an override apply.
+	Overrides map[transport.FunctionID]RetryPolicy
+}
+
+// DefaultRetryPolicy returns the policy of the host specification, with the
+// longer callback timeout SendData needs.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		AckTimeout:      DefaultAckTimeout,
+		ResponseTimeout: DefaultResponseTimeout,
+		CallbackTimeout: DefaultCallbackTimeout,
+		MaxRetransmits:  DefaultMaxRetransmits,
+		Backoff:         DefaultBackoff,
+		Overrides: map[transport.FunctionID]RetryPolicy{
+			transport.FuncSendData: {CallbackTimeout: SendDataCallbackTimeout},
+		},
+	}
+}
+
+// For returns the policy for requests of function id, with defaults filled
+// in.
+func (p RetryPolicy) For(id transport.FunctionID) RetryPolicy {
+	if o, ok := p.Overrides[id]; ok {
+		p = p.merge(o)
+	}
+	p.Overrides = nil
+	if p.AckTimeout == 0 {
+		p.AckTimeout = DefaultAckTimeout
+	}
+	if p.ResponseTimeout == 0 {
+		p.ResponseTimeout = DefaultResponseTimeout
+	}
+	if p.CallbackTimeout == 0 {
+		p.CallbackTimeout = DefaultCallbackTimeout
+	}
+	if p.MaxRetransmits == 0 {
+		p.MaxRetransmits = DefaultMaxRetransmits
+	}
+	if p.Backoff == nil {
+		p.Backoff = DefaultBackoff
+	}
+	return p
+}
+
+// merge returns p with the non-zero fields of o.
+func (p RetryPolicy) merge(o RetryPolicy) RetryPolicy {
+	if o.AckTimeout != 0 {
+		p.AckTimeout = o.AckTimeout
+	}
+	if o.ResponseTimeout != 0 {
+		p.ResponseTimeout = o.ResponseTimeout
+	}
+	if o.CallbackTimeout != 0 {
+		p.CallbackTimeout = o.CallbackTimeout
+	}
+	if o.MaxRetransmits != 0 {
+		p.MaxRetransmits = o.MaxRetransmits
+	}
+	if o.Backoff != nil {
+		p.Backoff = o.Backoff
+	}
+	if o.NoRetryOnCAN {
+		p.NoRetryOnCAN = true
+	}
+	if o.CANDelay != 0 {
+		p.CANDelay = o.CANDelay
+	}
+	return p
+}
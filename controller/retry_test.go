@@ -0,0 +1,119 @@
+package controller
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jbielick/zwgo/capture"
+	"github.com/jbielick/zwgo/port"
+	"github.com/jbielick/zwgo/transport"
+	"github.com/stretchr/testify/assert"
+	"github.com/tarm/serial"
+)
+
+func TestRetryPolicyFor(t *testing.T) {
+	p := DefaultRetryPolicy()
+	assert.Equal(t, DefaultAckTimeout, p.For(transport.FuncLibraryVersion).AckTimeout)
+	assert.Equal(t, DefaultCallbackTimeout, p.For(transport.FuncRequestNodeInfo).CallbackTimeout)
+	assert.Equal(t, SendDataCallbackTimeout, p.For(transport.FuncSendData).CallbackTimeout)
+	assert.Equal(t, 1100*time.Millisecond, p.For(transport.FuncSendData).Backoff(1))
+
+	var zero RetryPolicy
+	zero.Overrides = map[transport.FunctionID]RetryPolicy{
+		transport.FuncCapabilities: {AckTimeout: 5 * time.Second, MaxRetransmits: 1},
+	}
+	assert.Equal(t, DefaultResponseTimeout, zero.For(transport.FuncLibraryVersion).ResponseTimeout)
+	assert.Equal(t, DefaultMaxRetransmits, zero.For(transport.FuncLibraryVersion).MaxRetransmits)
+	assert.False(t, zero.For(transport.FuncLibraryVersion).NoRetryOnCAN)
+	assert.Equal(t, 5*time.Second, zero.For(transport.FuncCapabilities).AckTimeout)
+	assert.Equal(t, 1, zero.For(transport.FuncCapabilities).MaxRetransmits)
+	assert.Nil(t, zero.For(transport.FuncCapabilities).Overrides)
+
+	none := RetryPolicy{MaxRetransmits: -1}
+	assert.Equal(t, -1, none.For(transport.FuncLibraryVersion).MaxRetransmits, "negative disables retransmission")
+}
+
+func TestRetryPolicyOverridesArePartial(t *testing.T) {
+	p := DefaultRetryPolicy()
+	p.AckTimeout = 5 * time.Second
+	p.MaxRetransmits = 6
+	sendData := p.For(transport.FuncSendData)
+	assert.Equal(t, 5*time.Second, sendData.AckTimeout)
+	assert.Equal(t, 6, sendData.MaxRetransmits)
+	assert.Equal(t, SendDataCallbackTimeout, sendData.CallbackTimeout)
+}
+
+// connectReplay connects a controller to a replay of records without
+// initializing the stick.
+func connectReplay(t *testing.T, policy RetryPolicy, records []*capture.Record) (*Controller, *capture.Replayer) {
+	replay := capture.NewRecordReplayer(records)
+	config := NewConfig("replay")
+	config.Retry = policy
+	config.OpenPort = func(*serial.Config) (port.Port, error) {
+		return replay, nil
+	}
+	c := New(config)
+	c.done = make(chan struct{})
+	c.closeOnce = new(sync.Once)
+	if err := c.connect(); err != nil {
+		t.Fatal(err)
+	}
+	c.setState(Connected, nil)
+	t.Cleanup(func() { c.Close() })
+	return c, replay
+}
+
+func TestRetransmit(t *testing.T) {
+	request := transport.NewRequest([]byte{0x15})
+	policy := DefaultRetryPolicy()
+	policy.AckTimeout = 20 * time.Millisecond
+	policy.Backoff = func(n int) time.Duration { return time.Millisecond }
+	c, replay := connectReplay(t, policy, []*capture.Record{
+		{Direction: capture.Out, Frame: request},
+		{Direction: capture.In, Frame: transport.NewNAK()},
+		{Direction: capture.Out, Frame: request},
+		{Direction: capture.In, Frame: transport.NewCAN()},
+		{Direction: capture.Out, Frame: request},
+		// no ACK in time
+		{Direction: capture.Out, Frame: request},
+		{Direction: capture.In, Frame: transport.NewACK()},
+	})
+
+	_, err := c.SendWithAcknowledgement(context.Background(), rawCommand{0x15})
+	assert.NoError(t, err)
+	assert.NoError(t, replay.Err())
+	assert.Equal(t, 0, replay.Remaining())
+}
+
+func TestRetransmitGivesUp(t *testing.T) {
+	request := transport.NewRequest([]byte{0x15})
+	policy := DefaultRetryPolicy()
+	policy.MaxRetransmits = 1
+	policy.Backoff = func(n int) time.Duration { return time.Millisecond }
+	c, replay := connectReplay(t, policy, []*capture.Record{
+		{Direction: capture.Out, Frame: request},
+		{Direction: capture.In, Frame: transport.NewNAK()},
+		{Direction: capture.Out, Frame: request},
+		{Direction: capture.In, Frame: transport.NewNAK()},
+	})
+
+	_, err := c.SendWithAcknowledgement(context.Background(), rawCommand{0x15})
+	assert.ErrorIs(t, err, ErrNAK)
+	assert.NoError(t, replay.Err())
+	assert.Equal(t, 0, replay.Remaining())
+}
+
+func TestNoRetransmitOnCAN(t *testing.T) {
+	policy := DefaultRetryPolicy()
+	policy.NoRetryOnCAN = true
+	c, replay := connectReplay(t, policy, []*capture.Record{
+		{Direction: capture.Out, Frame: transport.NewRequest([]byte{0x15})},
+		{Direction: capture.In, Frame: transport.NewCAN()},
+	})
+
+	_, err := c.SendWithAcknowledgement(context.Background(), rawCommand{0x15})
+	assert.ErrorIs(t, err, ErrCAN)
+	assert.NoError(t, replay.Err())
+}
@@ -0,0 +1,63 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/jbielick/zwgo/emulator"
+	"github.com/stretchr/testify/assert"
+)
+
+// openPTY opens a pseudo-terminal and returns its master and the name of its
+// slave device.
+func openPTY(t *testing.T) (*os.File, string) {
+	t.Helper()
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("no pseudo-terminals: %s", err)
+	}
+	var unlock int32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); errno != 0 {
+		master.Close()
+		t.Fatal(errno)
+	}
+	var n uint32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); errno != 0 {
+		master.Close()
+		t.Fatal(errno)
+	}
+	return master, fmt.Sprintf("/dev/pts/%d", n)
+}
+
+func TestIdleSerialPortStaysConnected(t *testing.T) {
+	master, name := openPTY(t)
+	defer master.Close()
+	stick := emulator.New(emulator.DefaultProfile())
+	go stick.Serve(master)
+
+	events := make(chan StateEvent, 16)
+	config := NewConfig(name)
+	config.Serial.ReadTimeout = 100 * time.Millisecond
+	config.ReconnectBackoff = 10 * time.Millisecond
+	config.OnStateChange = func(e StateEvent) { events <- e }
+	c := New(config)
+	assert.NoError(t, c.Open(context.Background()))
+	defer c.Close()
+	assert.Equal(t, Connecting, nextState(t, events).State)
+	assert.Equal(t, Connected, nextState(t, events).State)
+
+	// several read timeouts pass without data
+	time.Sleep(500 * time.Millisecond)
+	select {
+	case e := <-events:
+		t.Fatalf("idle port changed state to %s: %v", e.State, e.Err)
+	default:
+	}
+	assert.Equal(t, Connected, c.State())
+	assert.Len(t, stick.Requests(), initRequests, "the stick is not reset again")
+}
@@ -0,0 +1,184 @@
+package controller
+
+import (
+	"context"
+	"time"
+
+	"github.com/jbielick/zwgo/logging"
+	"github.com/jbielick/zwgo/port"
+	"github.com/jbielick/zwgo/transport"
+)
+
+const (
+	DefaultReconnectBackoff    = 500 * time.Millisecond
+	DefaultMaxReconnectBackoff = 30 * time.Second
+)
+
+// ConnectionState is the state of the controller's connection to the stick.
+type ConnectionState int
+
+const (
+	Disconnected ConnectionState = iota
+	// Connecting means the port is open and the stick is being initialized.
+	Connecting
+	Connected
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case Disconnected:
+		return "disconnected"
+	case Connecting:
+		return "connecting"
+	case Connected:
+		return "connected"
+	}
+	return "unknown"
+}
+
+// StateEvent reports a change of connection state. Err is the reason the
+// connection was lost or could not be established, if any.
+type StateEvent struct {
+	State ConnectionState
+	Err   error
+}
+
+// State returns the current connection state.
+func (c *Controller) State() ConnectionState {
+	c.connMu.RLock()
+	defer c.connMu.RUnlock()
+	return c.state
+}
+
+func (c *Controller) setState(state ConnectionState, err error) {
+	c.connMu.Lock()
+	c.state = state
+	c.connMu.Unlock()
+	c.emit(state, err)
+}
+
+func (c *Controller) emit(state ConnectionState, err error) {
+	if c.Config.OnStateChange != nil {
+		c.Config.OnStateChange(StateEvent{State: state, Err: err})
+	}
+}
+
+// connect opens the port and starts reading from it.
+func (c *Controller) connect() error {
+	open := c.Config.OpenPort
+	if open == nil {
+		open = port.Open
+	}
+	p, err := open(&c.Config.Serial)
+	if err != nil {
+		return err
+	}
+	down := make(chan struct{})
+	c.connMu.Lock()
+	if c.closed() {
+		c.connMu.Unlock()
+		p.Close()
+		return ErrClosed
+	}
+	c.Port = p
+	c.encoder = transport.NewEncoder(p)
+	c.down = down
+	c.connMu.Unlock()
+	c.flush()
+
+	c.setState(Connecting, nil)
+	c.wg.Add(1)
+	go c.receive(p, down)
+	return nil
+}
+
+// connection returns the encoder of the current connection and a channel
+// that is closed when that connection is lost.
+func (c *Controller) connection() (*transport.Encoder, chan struct{}) {
+	c.connMu.RLock()
+	defer c.connMu.RUnlock()
+	return c.encoder, c.down
+}
+
+// disconnected closes the connection whose down channel is down after
+// reading or writing failed with err. Requests waiting on the connection fail
+// with ErrDisconnected. If the controller was connected, the supervisor is
+// told to reconnect.
+func (c *Controller) disconnected(down chan struct{}, err error) {
+	c.connMu.Lock()
+	if c.down != down || c.state == Disconnected {
+		c.connMu.Unlock()
+		return
+	}
+	wasConnected := c.state == Connected
+	c.state = Disconnected
+	close(down)
+	p := c.Port
+	c.connMu.Unlock()
+
+	p.Close()
+	c.emit(Disconnected, err)
+	if wasConnected {
+		select {
+		case c.lost <- err:
+		default:
+		}
+	}
+}
+
+// connErr is the error for a request whose connection was lost.
+func (c *Controller) connErr() error {
+	if c.closed() {
+		return ErrClosed
+	}
+	return ErrDisconnected
+}
+
+// supervise reconnects to the stick whenever the connection is lost and
+// initializes it again when it restarts, until the controller is closed.
+func (c *Controller) supervise() {
+	defer c.wg.Done()
+	for {
+		select {
+		case err := <-c.lost:
+			c.log.Log(logging.Error, "lost connection", logging.F("port", c.Config.Serial.Name), logging.F("error", err))
+			if c.Config.ReconnectBackoff > 0 {
+				c.reconnect()
+			}
+		case started := <-c.restarted:
+			c.reinitialize(started)
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// reconnect reopens the port and re-initializes the stick, backing off
+// exponentially between attempts.
+func (c *Controller) reconnect() {
+	backoff := c.Config.ReconnectBackoff
+	for {
+		if err := c.sleep(context.Background(), backoff); err != nil {
+			return
+		}
+		err := c.connect()
+		if err == nil {
+			_, down := c.connection()
+			if err = c.start(context.Background()); err == nil {
+				c.setState(Connected, nil)
+				c.log.Log(logging.Info, "reconnected", logging.F("port", c.Config.Serial.Name))
+				return
+			}
+			c.disconnected(down, err)
+		}
+		if c.closed() {
+			return
+		}
+		c.log.Log(logging.Warn, "failed to reconnect", logging.F("port", c.Config.Serial.Name), logging.F("error", err), logging.F("backoff", backoff))
+
+		backoff *= 2
+		if max := c.Config.MaxReconnectBackoff; max > 0 && backoff > max {
+			backoff = max
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jbielick/zwgo/emulator"
+	"github.com/jbielick/zwgo/hostapi/capabilities/v0"
+	"github.com/jbielick/zwgo/port"
+	"github.com/stretchr/testify/assert"
+	"github.com/tarm/serial"
+)
+
+func nextState(t *testing.T, events chan StateEvent) StateEvent {
+	t.Helper()
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a connection state event")
+		return StateEvent{}
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	var unplugged int32
+	events := make(chan StateEvent, 16)
+	c, stick := openEmulator(t, silentProfile(), func(config *Config) {
+		config.ReconnectBackoff = 10 * time.Millisecond
+		config.MaxReconnectBackoff = 20 * time.Millisecond
+		open := config.OpenPort
+		config.OpenPort = func(config *serial.Config) (port.Port, error) {
+			if atomic.LoadInt32(&unplugged) != 0 {
+				return nil, errors.New("no such device")
+			}
+			return open(config)
+		}
+		config.OnStateChange = func(e StateEvent) { events <- e }
+	})
+	assert.Equal(t, Connecting, nextState(t, events).State)
+	assert.Equal(t, Connected, nextState(t, events).State)
+	assert.Equal(t, Connected, c.State())
+
+	inflight := make(chan error, 1)
+	go func() {
+		var resp rawReport
+		inflight <- c.SendAndReceive(context.Background(), rawCommand{unansweredFunction}, &resp)
+	}()
+	for len(stick.Requests()) < initRequests+1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	atomic.StoreInt32(&unplugged, 1)
+	stick.Unplug()
+	e := nextState(t, events)
+	assert.Equal(t, Disconnected, e.State)
+	assert.Error(t, e.Err)
+	assert.ErrorIs(t, <-inflight, ErrDisconnected)
+
+	_, err := capabilities.NewLibraryVersionGet().SendContext(context.Background(), c)
+	assert.ErrorIs(t, err, ErrDisconnected)
+
+	atomic.StoreInt32(&unplugged, 0)
+	assert.Equal(t, Connecting, nextState(t, events).State)
+	assert.Equal(t, Connected, nextState(t, events).State)
+	assert.Len(t, stick.Requests(), 2*initRequests+1, "the stick is reset and initialized again")
+
+	report, err := capabilities.NewLibraryVersionGet().SendContext(context.Background(), c)
+	assert.NoError(t, err)
+	assert.Equal(t, "Z-Wave 7.16\x00", report.Version)
+
+	assert.NoError(t, c.Close())
+	e = nextState(t, events)
+	assert.Equal(t, Disconnected, e.State)
+	assert.ErrorIs(t, e.Err, ErrClosed)
+}
+
+func TestReconnectDisabled(t *testing.T) {
+	opened := 0
+	c, stick := openEmulator(t, emulator.DefaultProfile(), func(config *Config) {
+		config.ReconnectBackoff = 0
+		open := config.OpenPort
+		config.OpenPort = func(config *serial.Config) (port.Port, error) {
+			opened++
+			return open(config)
+		}
+	})
+
+	stick.Unplug()
+	for c.State() != Disconnected {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, 1, opened)
+	_, err := capabilities.NewLibraryVersionGet().SendContext(context.Background(), c)
+	assert.ErrorIs(t, err, ErrDisconnected)
+}
+
+func TestReconnectTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	stick := emulator.New(emulator.DefaultProfile())
+	conns := make(chan net.Conn, 2)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conns <- conn
+			go stick.Serve(conn)
+		}
+	}()
+
+	events := make(chan StateEvent, 16)
+	config := NewConfig("tcp://" + l.Addr().String())
+	config.ReconnectBackoff = 10 * time.Millisecond
+	config.OnStateChange = func(e StateEvent) { events <- e }
+	c := New(config)
+	assert.NoError(t, c.Open(context.Background()))
+	defer c.Close()
+	assert.Equal(t, Connecting, nextState(t, events).State)
+	assert.Equal(t, Connected, nextState(t, events).State)
+
+	// the serial server drops the connection
+	(<-conns).Close()
+	assert.Equal(t, Disconnected, nextState(t, events).State)
+	assert.Equal(t, Connecting, nextState(t, events).State)
+	assert.Equal(t, Connected, nextState(t, events).State)
+	assert.Len(t, stick.Requests(), 2*initRequests, "the stick is reset and initialized again")
+	defer (<-conns).Close()
+}
@@ -0,0 +1,350 @@
+// Package emulator emulates a Z-Wave stick on the far end of a byte stream.
+// It acknowledges every data frame, answers the Serial API functions a
+// controller uses during initialization from a Profile, reports transmission
+// callbacks and can send unsolicited frames on demand. It is meant for
+// end-to-end tests of the controller without hardware.
+package emulator
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/jbielick/zwgo/port"
+	"github.com/jbielick/zwgo/transport"
+	"github.com/tarm/serial"
+)
+
+const (
+	// WakeUpSoftwareReset is the wake-up reason reported after SOFT_RESET.
+	WakeUpSoftwareReset = 0x07
+	// WakeUpWatchdog is the wake-up reason reported after a watchdog reset.
+	WakeUpWatchdog = 0x03
+)
+
+const (
+	libraryVersionLength = 12
+	functionBitmaskSize  = 32
+	nodeBitmaskSize      = 29
+)
+
+// Profile describes the stick being emulated.
+type Profile struct {
+	LibraryVersion string
+	LibraryType    byte
+
+	SerialAPIVersion  byte
+	SerialAPIRevision byte
+	ManufacturerID    uint16
+	ProductType       uint16
+	ProductID         uint16
+	Functions         []transport.FunctionID
+
+	InitDataVersion      byte
+	InitDataCapabilities byte
+	Nodes                []byte
+	ChipType             byte
+	ChipVersion          byte
+
+	HomeID uint32
+	NodeID byte
+
+	ControllerCapabilities byte
+
+	// TransmitStatus is reported in SendData callbacks.
+	TransmitStatus byte
+
+	// Started controls whether the stick reports SERIAL_API_STARTED after a
+	// soft reset, which older firmware does not.
+	Started bool
+	// CommandClasses are the command classes reported in
+	// SERIAL_API_STARTED.
+	CommandClasses []byte
+	LongRange      bool
+}
+
+// DefaultProfile is a 700-series static controller with node 1 as the only
+// node in its network.
+func DefaultProfile() Profile {
+	return Profile{
+		LibraryVersion:    "Z-Wave 7.16",
+		LibraryType:       0x01,
+		SerialAPIVersion:  0x01,
+		SerialAPIRevision: 0x02,
+		ManufacturerID:    0x0000,
+		ProductType:       0x0004,
+		ProductID:         0x0004,
+		Functions: []transport.FunctionID{
+			transport.FuncInitData,
+			transport.FuncApplicationCommandHandler,
+			transport.FuncControllerCapabilities,
+			transport.FuncSetTimeouts,
+			transport.FuncCapabilities,
+			transport.FuncSoftReset,
+			transport.FuncSendData,
+			transport.FuncLibraryVersion,
+			transport.FuncMemoryGetID,
+			transport.FuncAddNodeToNetwork,
+			transport.FuncRemoveNodeFromNetwork,
+			transport.FuncRemoveFailedNode,
+		},
+		InitDataVersion: 0x08,
+		Nodes:           []byte{1},
+		ChipType:        0x07,
+		ChipVersion:     0x00,
+		HomeID:          0xC0FFEE00,
+		NodeID:          1,
+		Started:         true,
+		CommandClasses:  []byte{0x5e, 0x86, 0x72},
+	}
+}
+
+// Handler answers a request frame, typically with Respond and, for functions
+// that report back later, Request.
+type Handler func(s *Stick, request *transport.Frame)
+
+// Stick is an emulated Z-Wave stick. Handlers may be replaced or added before
+// Serve is called.
+type Stick struct {
+	Profile  Profile
+	Handlers map[transport.FunctionID]Handler
+
+	mu       sync.Mutex
+	out      chan *transport.Frame
+	conn     net.Conn
+	requests []*transport.Frame
+	acks     int
+}
+
+func New(profile Profile) *Stick {
+	return &Stick{
+		Profile: profile,
+		Handlers: map[transport.FunctionID]Handler{
+			transport.FuncLibraryVersion:         libraryVersion,
+			transport.FuncCapabilities:           capabilities,
+			transport.FuncInitData:               initData,
+			transport.FuncMemoryGetID:            memoryGetID,
+			transport.FuncControllerCapabilities: controllerCapabilities,
+			transport.FuncSendData:               sendData,
+			transport.FuncSoftReset:              softReset,
+		},
+	}
+}
+
+// Serve answers frames read from rw until reading fails. Frames are written
+// from a separate goroutine, so the stick keeps reading while the other end
+// is busy.
+func (s *Stick) Serve(rw io.ReadWriter) error {
+	out := make(chan *transport.Frame, 64)
+	s.mu.Lock()
+	s.out = out
+	s.mu.Unlock()
+	go func() {
+		e := transport.NewEncoder(rw)
+		for f := range out {
+			e.Encode(f)
+		}
+	}()
+	defer func() {
+		s.mu.Lock()
+		if s.out == out {
+			s.out = nil
+		}
+		close(out)
+		s.mu.Unlock()
+	}()
+
+	d := transport.NewDecoder(rw)
+	for {
+		frame, err := d.Next()
+		if err != nil {
+			switch err.(type) {
+			case *transport.CorruptFrameError:
+				s.send(transport.NewNAK())
+				continue
+			case *transport.FrameTimeoutError:
+				continue
+			}
+			return err
+		}
+		if !frame.IsDataFrame() {
+			if frame.IsACK() {
+				s.mu.Lock()
+				s.acks++
+				s.mu.Unlock()
+			}
+			continue
+		}
+		if err := s.send(transport.NewACK()); err != nil {
+			return err
+		}
+		if !frame.IsRequest() {
+			continue
+		}
+		s.mu.Lock()
+		s.requests = append(s.requests, frame)
+		s.mu.Unlock()
+
+		id, _ := frame.FunctionID()
+		if handler, ok := s.Handlers[id]; ok {
+			handler(s, frame)
+		}
+	}
+}
+
+// Open connects a new in-memory port to the stick and serves it. It has the
+// signature of a port.Opener so it can be used as a controller's OpenPort.
+func (s *Stick) Open(*serial.Config) (port.Port, error) {
+	client, server := net.Pipe()
+	s.mu.Lock()
+	s.conn = server
+	s.mu.Unlock()
+	go func() {
+		defer server.Close()
+		s.Serve(server)
+	}()
+	return client, nil
+}
+
+// Unplug closes the port most recently returned by Open, as if the stick had
+// been removed.
+func (s *Stick) Unplug() {
+	s.mu.Lock()
+	conn := s.conn
+	s.conn = nil
+	s.mu.Unlock()
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+func (s *Stick) send(f *transport.Frame) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.out == nil {
+		return io.ErrClosedPipe
+	}
+	s.out <- f
+	return nil
+}
+
+// Respond sends a response frame with the given function and parameters.
+func (s *Stick) Respond(id transport.FunctionID, params ...byte) error {
+	return s.send(transport.NewResponse(append([]byte{byte(id)}, params...)))
+}
+
+// Request sends an unsolicited request frame with the given function and
+// parameters, such as a callback.
+func (s *Stick) Request(id transport.FunctionID, params ...byte) error {
+	return s.send(transport.NewRequest(append([]byte{byte(id)}, params...)))
+}
+
+// ApplicationCommand sends an APPLICATION_COMMAND_HANDLER request carrying a
+// command class command received from node.
+func (s *Stick) ApplicationCommand(node byte, command []byte) error {
+	params := []byte{0x00, node, byte(len(command))}
+	return s.Request(transport.FuncApplicationCommandHandler, append(params, command...)...)
+}
+
+// Restart sends SERIAL_API_STARTED with the given wake-up reason, as the
+// stick does whenever its Serial API starts.
+func (s *Stick) Restart(reason byte) error {
+	p := s.Profile
+	var protocols byte
+	if p.LongRange {
+		protocols |= 0x01
+	}
+	// watchdog not started, listening static controller
+	params := []byte{reason, 0x00, 0x01, 0x02, 0x01, byte(len(p.CommandClasses))}
+	params = append(params, p.CommandClasses...)
+	return s.Request(transport.FuncSerialAPIStarted, append(params, protocols)...)
+}
+
+// Requests returns the request frames received so far.
+func (s *Stick) Requests() []*transport.Frame {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*transport.Frame(nil), s.requests...)
+}
+
+// ACKs returns the number of ACK frames received so far.
+func (s *Stick) ACKs() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.acks
+}
+
+func libraryVersion(s *Stick, req *transport.Frame) {
+	version := make([]byte, libraryVersionLength)
+	copy(version[:libraryVersionLength-1], s.Profile.LibraryVersion)
+	s.Respond(transport.FuncLibraryVersion, append(version, s.Profile.LibraryType)...)
+}
+
+func capabilities(s *Stick, req *transport.Frame) {
+	p := s.Profile
+	params := []byte{p.SerialAPIVersion, p.SerialAPIRevision}
+	params = appendUint16(params, p.ManufacturerID)
+	params = appendUint16(params, p.ProductType)
+	params = appendUint16(params, p.ProductID)
+	functions := make([]byte, functionBitmaskSize)
+	for _, id := range p.Functions {
+		setBit(functions, byte(id))
+	}
+	s.Respond(transport.FuncCapabilities, append(params, functions...)...)
+}
+
+func initData(s *Stick, req *transport.Frame) {
+	p := s.Profile
+	nodes := make([]byte, nodeBitmaskSize)
+	for _, id := range p.Nodes {
+		setBit(nodes, id)
+	}
+	params := []byte{p.InitDataVersion, p.InitDataCapabilities, nodeBitmaskSize}
+	params = append(params, nodes...)
+	s.Respond(transport.FuncInitData, append(params, p.ChipType, p.ChipVersion)...)
+}
+
+func memoryGetID(s *Stick, req *transport.Frame) {
+	params := appendUint32(nil, s.Profile.HomeID)
+	s.Respond(transport.FuncMemoryGetID, append(params, s.Profile.NodeID)...)
+}
+
+func controllerCapabilities(s *Stick, req *transport.Frame) {
+	s.Respond(transport.FuncControllerCapabilities, s.Profile.ControllerCapabilities)
+}
+
+func softReset(s *Stick, req *transport.Frame) {
+	if s.Profile.Started {
+		s.Restart(WakeUpSoftwareReset)
+	}
+}
+
+// sendData accepts the transmission and reports it through the callback ID
+// in the last byte of the request.
+func sendData(s *Stick, req *transport.Frame) {
+	s.Respond(transport.FuncSendData, 0x01)
+	if callbackID := req.Payload[len(req.Payload)-1]; callbackID != 0 {
+		s.Request(transport.FuncSendData, callbackID, s.Profile.TransmitStatus)
+	}
+}
+
+// setBit sets the bit for the 1-based value v in a Z-Wave bitmask.
+func setBit(mask []byte, v byte) {
+	if v == 0 || int(v-1)/8 >= len(mask) {
+		return
+	}
+	mask[(v-1)/8] |= 1 << ((v - 1) % 8)
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	buf := make([]byte, 2)
+	binary.BigEndian.PutUint16(buf, v)
+	return append(b, buf...)
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, v)
+	return append(b, buf...)
+}
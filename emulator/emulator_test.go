@@ -0,0 +1,146 @@
+package emulator
+
+import (
+	"testing"
+
+	"github.com/jbielick/zwgo/port"
+	"github.com/jbielick/zwgo/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+type host struct {
+	t *testing.T
+	p port.Port
+	d *transport.Decoder
+	e *transport.Encoder
+}
+
+func connect(t *testing.T, s *Stick) *host {
+	p, err := s.Open(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Close() })
+	return &host{t, p, transport.NewDecoder(p), transport.NewEncoder(p)}
+}
+
+func (h *host) send(f *transport.Frame) {
+	if _, err := h.e.Encode(f); err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+func (h *host) next() *transport.Frame {
+	f, err := h.d.Next()
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	return f
+}
+
+// call sends a request and returns the response after the ACK.
+func (h *host) call(payload ...byte) *transport.Frame {
+	h.send(transport.NewRequest(payload))
+	assert.True(h.t, h.next().IsACK())
+	resp := h.next()
+	assert.True(h.t, resp.IsResponse())
+	h.send(transport.NewACK())
+	return resp
+}
+
+func TestLibraryVersion(t *testing.T) {
+	h := connect(t, New(DefaultProfile()))
+	resp := h.call(byte(transport.FuncLibraryVersion))
+	assert.Equal(t, []byte("\x15Z-Wave 7.16\x00\x01"), resp.Payload)
+}
+
+func TestCapabilities(t *testing.T) {
+	profile := DefaultProfile()
+	profile.ManufacturerID = 0x0086
+	profile.Functions = []transport.FunctionID{transport.FuncInitData, transport.FuncLibraryVersion}
+	h := connect(t, New(profile))
+
+	resp := h.call(byte(transport.FuncCapabilities))
+	assert.Len(t, resp.Payload, 1+8+functionBitmaskSize)
+	assert.Equal(t, []byte{0x00, 0x86}, resp.Payload[3:5])
+	functions := resp.Payload[9:]
+	assert.Equal(t, byte(0x02), functions[0])
+	assert.Equal(t, byte(0x10), functions[2])
+}
+
+func TestInitData(t *testing.T) {
+	profile := DefaultProfile()
+	profile.Nodes = []byte{1, 2, 9, 232}
+	h := connect(t, New(profile))
+
+	resp := h.call(byte(transport.FuncInitData))
+	assert.Len(t, resp.Payload, 1+3+nodeBitmaskSize+2)
+	nodes := resp.Payload[4 : 4+nodeBitmaskSize]
+	assert.Equal(t, byte(0x03), nodes[0])
+	assert.Equal(t, byte(0x01), nodes[1])
+	assert.Equal(t, byte(0x80), nodes[28])
+}
+
+func TestMemoryGetID(t *testing.T) {
+	h := connect(t, New(DefaultProfile()))
+	resp := h.call(byte(transport.FuncMemoryGetID))
+	assert.Equal(t, []byte{0x20, 0xc0, 0xff, 0xee, 0x00, 0x01}, resp.Payload)
+}
+
+func TestSendDataCallback(t *testing.T) {
+	profile := DefaultProfile()
+	profile.TransmitStatus = 0x01
+	s := New(profile)
+	h := connect(t, s)
+
+	resp := h.call(byte(transport.FuncSendData), 0x02, 0x02, 0x20, 0x02, 0x25, 0x07)
+	assert.Equal(t, []byte{0x13, 0x01}, resp.Payload)
+	callback := h.next()
+	assert.True(t, callback.IsRequest())
+	assert.Equal(t, []byte{0x13, 0x07, 0x01}, callback.Payload)
+	h.send(transport.NewACK())
+
+	assert.Len(t, s.Requests(), 1)
+}
+
+func TestUnknownFunctionIsOnlyAcknowledged(t *testing.T) {
+	s := New(DefaultProfile())
+	h := connect(t, s)
+	h.send(transport.NewRequest([]byte{0xee}))
+	assert.True(t, h.next().IsACK())
+
+	assert.NoError(t, s.ApplicationCommand(3, []byte{0x25, 0x03, 0xff}))
+	frame := h.next()
+	assert.True(t, frame.IsRequest())
+	assert.Equal(t, []byte{0x04, 0x00, 0x03, 0x03, 0x25, 0x03, 0xff}, frame.Payload)
+}
+
+func TestCorruptFrameIsNAKed(t *testing.T) {
+	h := connect(t, New(DefaultProfile()))
+	_, err := h.p.Write([]byte{0x01, 0x03, 0x00, 0x15, 0x00})
+	assert.NoError(t, err)
+	assert.True(t, h.next().IsNAK())
+}
+
+func TestSendBeforeServe(t *testing.T) {
+	assert.Error(t, New(DefaultProfile()).Request(transport.FuncSendData, 0x01))
+}
+
+func TestSoftReset(t *testing.T) {
+	profile := DefaultProfile()
+	profile.LongRange = true
+	h := connect(t, New(profile))
+	h.send(transport.NewRequest([]byte{byte(transport.FuncSoftReset)}))
+	assert.True(t, h.next().IsACK())
+	started := h.next()
+	assert.True(t, started.IsRequest())
+	assert.Equal(t, []byte{0x0a, WakeUpSoftwareReset, 0x00, 0x01, 0x02, 0x01, 0x03, 0x5e, 0x86, 0x72, 0x01}, started.Payload)
+}
+
+func TestUnplug(t *testing.T) {
+	s := New(DefaultProfile())
+	h := connect(t, s)
+	s.Unplug()
+	_, err := h.d.Next()
+	assert.Error(t, err)
+}
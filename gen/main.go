@@ -99,11 +99,21 @@ func groupCommands(cc *CommandClassDef) {
 var controllerTemplate = `
 package %s
 
-import "encoding"
+import (
+	"context"
+	"encoding"
+	"fmt"
+)
 
 type Controller interface {
-	SendAndReceive(encoding.BinaryMarshaler, encoding.BinaryUnmarshaler) error
-	SendWithAcknowledgement(encoding.BinaryMarshaler) (int, error)
+	SendAndReceive(context.Context, encoding.BinaryMarshaler, encoding.BinaryUnmarshaler) error
+	SendWithAcknowledgement(context.Context, encoding.BinaryMarshaler) (int, error)
+}
+
+// shortReport is returned by UnmarshalBinary when data ends before every
+// field of the named report has been read.
+func shortReport(name string, data []byte) error {
+	return fmt.Errorf("%%s: short report (%%d bytes)", name, len(data))
 }
 `
 
@@ -177,8 +187,10 @@ func goTypeString(param IParam) string {
 		} else {
 			return "string"
 		}
-	case "WORD", "DWORD":
-		return "byte"
+	case "WORD":
+		return "uint16"
+	case "DWORD":
+		return "uint32"
 	case "BIT_24":
 		return "[]byte"
 	case "CONST", "STRUCT_BYTE":
@@ -3,6 +3,8 @@
 
 package {{ .Command.Class.PackageName }} // {{ .Command.Class.Key }}
 
+import "context"
+
 {{- range $param := .Command.AllParams }}
 {{- if eq $param.Type "ENUM" }}
 //go:generate stringer -type={{ fieldName $param }}
@@ -52,12 +54,20 @@ func (c {{ .Command.StructName }}) Comment() string {
 
 {{- if and .Command.IsGet .Command.Report }}
 func (cmd {{ .Command.StructName }}) Send(c Controller) ({{ .Command.Report.StructName }}, error) {
+	return cmd.SendContext(context.Background(), c)
+}
+
+func (cmd {{ .Command.StructName }}) SendContext(ctx context.Context, c Controller) ({{ .Command.Report.StructName }}, error) {
 	r := {{ .Command.Report.StructName }}{}
-	err := c.SendAndReceive(cmd, &r)
+	err := c.SendAndReceive(ctx, cmd, &r)
 	return r, err
 {{- else }}
 func (cmd *{{ .Command.StructName }}) Send(c Controller) (error) {
-  _, err := c.SendWithAcknowledgement(cmd)
+  return cmd.SendContext(context.Background(), c)
+}
+
+func (cmd *{{ .Command.StructName }}) SendContext(ctx context.Context, c Controller) (error) {
+  _, err := c.SendWithAcknowledgement(ctx, cmd)
   return err
 {{- end }}
 }
@@ -16,7 +16,9 @@ func (c {{ .Command.StructName }}) MarshalBinary() ([]byte, error) {
     {{- else if eq $param.Type "BYTE" }}
   payload = append(payload, c.{{ fieldName $param }})
     {{- else if eq $param.Type "WORD" }}
-  payload = append(payload, c.{{ fieldName $param }})
+  payload = append(payload, byte(c.{{ fieldName $param }}>>8), byte(c.{{ fieldName $param }}))
+    {{- else if eq $param.Type "DWORD" }}
+  payload = append(payload, byte(c.{{ fieldName $param }}>>24), byte(c.{{ fieldName $param }}>>16), byte(c.{{ fieldName $param }}>>8), byte(c.{{ fieldName $param }}))
     {{- else }}
   // marshal {{ $param.Key }} {{ $param.Index }}
     {{- end }}
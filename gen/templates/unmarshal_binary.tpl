@@ -8,9 +8,15 @@ func (c *{{ .Command.StructName }}) UnmarshalBinary(data []byte) error {
 
   {{- range $param := .Command.AllParams }}
     {{- if eq $param.Type "ENUM" }}
+  if len(data) < pos+1 {
+    return shortReport(c.Name(), data)
+  }
   c.{{ fieldName . }} = {{ fieldName . }}(data[pos])
   pos++
     {{- else if eq $param.Type "ARRAY" }}
+  if len(data) < pos+{{ $param.ArrayAttribute.Length }} {
+    return shortReport(c.Name(), data)
+  }
       {{- if $param.ArrayAttribute.ShowHex }}
   c.{{ fieldName $param }} = data[pos:pos+{{ $param.ArrayAttribute.Length }}]
       {{- else }}
@@ -18,11 +24,23 @@ func (c *{{ .Command.StructName }}) UnmarshalBinary(data []byte) error {
       {{- end }}
   pos = pos+{{ $param.ArrayAttribute.Length }}
     {{- else if eq $param.Type "BYTE" }}
+  if len(data) < pos+1 {
+    return shortReport(c.Name(), data)
+  }
   c.{{ fieldName $param }} = data[pos]
   pos++
     {{- else if eq $param.Type "WORD" }}
-  c.{{ fieldName $param }} = data[pos]
-  pos++
+  if len(data) < pos+2 {
+    return shortReport(c.Name(), data)
+  }
+  c.{{ fieldName $param }} = uint16(data[pos])<<8 | uint16(data[pos+1])
+  pos += 2
+    {{- else if eq $param.Type "DWORD" }}
+  if len(data) < pos+4 {
+    return shortReport(c.Name(), data)
+  }
+  c.{{ fieldName $param }} = uint32(data[pos])<<24 | uint32(data[pos+1])<<16 | uint32(data[pos+2])<<8 | uint32(data[pos+3])
+  pos += 4
     {{- else }}
   // marshal {{ $param.Key }} {{ $param.Index }}
   pos++
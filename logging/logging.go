@@ -0,0 +1,170 @@
+// Package logging defines the structured logger zwgo's packages log
+// through. Nothing is logged unless a Logger is configured, and no global
+// logging state is touched. Adapting another logging library only takes
+// implementing Log.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log entry.
+type Level int8
+
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l >= 0 && int(l) < len(levelNames) {
+		return levelNames[l]
+	}
+	return fmt.Sprintf("Level(%d)", int(l))
+}
+
+// ParseLevel returns the level with the given name.
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q", s)
+}
+
+// Field is a key-value pair attached to a log entry.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// F returns a field.
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Logger receives log entries.
+type Logger interface {
+	Log(level Level, msg string, fields ...Field)
+}
+
+type nop struct{}
+
+func (nop) Log(Level, string, ...Field) {}
+
+// Nop discards every entry.
+var Nop Logger = nop{}
+
+// OrNop returns l, or Nop if l is nil.
+func OrNop(l Logger) Logger {
+	if l == nil {
+		return Nop
+	}
+	return l
+}
+
+type with struct {
+	logger Logger
+	fields []Field
+}
+
+func (w *with) Log(level Level, msg string, fields ...Field) {
+	all := make([]Field, 0, len(w.fields)+len(fields))
+	all = append(all, w.fields...)
+	w.logger.Log(level, msg, append(all, fields...)...)
+}
+
+// With returns a logger that adds fields to every entry logged through it.
+func With(l Logger, fields ...Field) Logger {
+	if l == Nop {
+		return Nop
+	}
+	if w, ok := l.(*with); ok {
+		return &with{logger: w.logger, fields: append(append([]Field(nil), w.fields...), fields...)}
+	}
+	return &with{logger: l, fields: fields}
+}
+
+// SubsystemKey is the field naming the part of zwgo an entry comes from,
+// such as "controller" or "transport".
+const SubsystemKey = "subsystem"
+
+// Subsystem returns a logger whose entries are attributed to subsystem name.
+func Subsystem(l Logger, name string) Logger {
+	return With(l, F(SubsystemKey, name))
+}
+
+// Levels passes on entries at or above a minimum level, which can be set per
+// subsystem.
+type Levels struct {
+	Logger Logger
+	// Default is the minimum level of subsystems without an entry in
+	// Subsystems.
+	Default    Level
+	Subsystems map[string]Level
+}
+
+func (l *Levels) Log(level Level, msg string, fields ...Field) {
+	min := l.Default
+	for _, f := range fields {
+		if f.Key != SubsystemKey {
+			continue
+		}
+		if name, ok := f.Value.(string); ok {
+			if v, ok := l.Subsystems[name]; ok {
+				min = v
+			}
+		}
+	}
+	if level >= min {
+		l.Logger.Log(level, msg, fields...)
+	}
+}
+
+type text struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewText returns a logger that writes entries to w as logfmt lines.
+func NewText(w io.Writer) Logger {
+	return &text{w: w}
+}
+
+func (t *text) Log(level Level, msg string, fields ...Field) {
+	var b strings.Builder
+	b.WriteString("time=")
+	b.WriteString(time.Now().Format(time.RFC3339Nano))
+	b.WriteString(" level=")
+	b.WriteString(level.String())
+	b.WriteString(" msg=")
+	b.WriteString(quote(msg))
+	for _, f := range fields {
+		b.WriteByte(' ')
+		b.WriteString(f.Key)
+		b.WriteByte('=')
+		b.WriteString(quote(fmt.Sprint(f.Value)))
+	}
+	b.WriteByte('\n')
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	io.WriteString(t.w, b.String())
+}
+
+// quote quotes s if it is empty or contains spaces, quotes or '='.
+func quote(s string) string {
+	if s == "" || strings.ContainsAny(s, " \"=\t\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
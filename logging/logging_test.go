@@ -0,0 +1,65 @@
+package logging
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type entry struct {
+	level  Level
+	msg    string
+	fields []Field
+}
+
+type recorder struct {
+	entries []entry
+}
+
+func (r *recorder) Log(level Level, msg string, fields ...Field) {
+	r.entries = append(r.entries, entry{level, msg, fields})
+}
+
+func TestWith(t *testing.T) {
+	r := &recorder{}
+	l := With(Subsystem(r, "controller"), F("node", 3))
+	l.Log(Info, "hello", F("attempt", 1))
+	assert.Equal(t, []entry{{Info, "hello", []Field{
+		{SubsystemKey, "controller"},
+		{"node", 3},
+		{"attempt", 1},
+	}}}, r.entries)
+	assert.Equal(t, Nop, With(Nop, F("a", 1)))
+	assert.Equal(t, Nop, OrNop(nil))
+}
+
+func TestLevels(t *testing.T) {
+	r := &recorder{}
+	l := &Levels{Logger: r, Default: Warn, Subsystems: map[string]Level{"transport": Debug}}
+	Subsystem(l, "controller").Log(Info, "dropped")
+	Subsystem(l, "controller").Log(Error, "kept")
+	Subsystem(l, "transport").Log(Debug, "kept")
+	l.Log(Info, "dropped")
+	assert.Len(t, r.entries, 2)
+	for _, e := range r.entries {
+		assert.Equal(t, "kept", e.msg)
+	}
+}
+
+func TestText(t *testing.T) {
+	var buf bytes.Buffer
+	NewText(&buf).Log(Warn, "failed to reconnect", F("addr", "host:1"), F("error", "connection refused"))
+	line := buf.String()
+	assert.True(t, strings.HasPrefix(line, "time="))
+	assert.Contains(t, line, ` level=warn msg="failed to reconnect" addr=host:1 error="connection refused"`+"\n")
+}
+
+func TestParseLevel(t *testing.T) {
+	level, err := ParseLevel("WARN")
+	assert.NoError(t, err)
+	assert.Equal(t, Warn, level)
+	_, err = ParseLevel("trace")
+	assert.Error(t, err)
+}
@@ -10,11 +10,15 @@ import (
 	"runtime"
 	"syscall"
 
+	"github.com/jbielick/zwgo/capture"
 	"github.com/jbielick/zwgo/controller"
+	"github.com/jbielick/zwgo/logging"
 	log "github.com/sirupsen/logrus"
 )
 
-var portFlag = flag.String("port", "", "Name of the zwave serial port (/dev/ttyACM0 for example). Use of this flag disables autodiscovery.")
+var portFlag = flag.String("port", "", "Name of the zwave serial port (/dev/ttyACM0 for example) or tcp://host:port of a ser2net server or rfc2217://host:port of an RFC 2217 server. Use of this flag disables autodiscovery.")
+var captureFlag = flag.String("capture", "", "Record every frame exchanged with the controller to this capture file. Files ending in .pcapng are written as pcapng.")
+var metricsFlag = flag.String("metrics", "", "Serve Prometheus metrics at /metrics on this address (:9100 for example).")
 var verbosityFlag = flag.String("log-level", "INFO", "Logging verbosity")
 
 func init() {
@@ -44,13 +48,21 @@ func main() {
 	}
 	log.Infof("Using port %s", port)
 
-	c := controller.New(controller.NewConfig(port))
-	err := c.Open()
-	if err != nil {
-		log.Fatal(err)
+	config := controller.NewConfig(port)
+	config.MetricsAddr = *metricsFlag
+	config.Logger = logrusLogger{}
+	if len(*captureFlag) > 0 {
+		f, err := os.Create(*captureFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		if filepath.Ext(*captureFlag) == ".pcapng" {
+			config.Capture = capture.NewPcapngWriter(f)
+		} else {
+			config.Capture = capture.NewWriter(f)
+		}
 	}
-	defer c.Close()
-	log.Printf("DEBUG: %+v\n", c)
 
 	s := make(chan os.Signal)
 	signal.Notify(s, os.Interrupt)
@@ -63,9 +75,41 @@ func main() {
 		cancel()
 	}()
 
+	c := controller.New(config)
+	err := c.Open(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer c.Close()
+	log.Printf("DEBUG: %+v\n", c)
+
+	c.SubscribeFunc(controller.Filter{}, 0, func(e controller.Event) {
+		log.Infof("event: %s", e.Frame())
+	})
+
 	<-ctx.Done()
 }
 
+// logrusLogger passes the controller's log entries to logrus.
+type logrusLogger struct{}
+
+func (logrusLogger) Log(level logging.Level, msg string, fields ...logging.Field) {
+	entry := log.NewEntry(log.StandardLogger())
+	for _, f := range fields {
+		entry = entry.WithField(f.Key, f.Value)
+	}
+	switch level {
+	case logging.Debug:
+		entry.Debug(msg)
+	case logging.Info:
+		entry.Info(msg)
+	case logging.Warn:
+		entry.Warn(msg)
+	default:
+		entry.Error(msg)
+	}
+}
+
 func discoverPorts() ([]string, error) {
 	var glob string
 	switch runtime.GOOS {
@@ -0,0 +1,288 @@
+// Package metrics implements the few Prometheus metric types the controller
+// exports: counters, histograms, and gauges and counters computed on demand,
+// optionally with labels. A Registry writes them in the Prometheus text exposition
+// format and serves them over HTTP.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// metric is a metric family that writes its samples in text format.
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+// Registry holds metrics in the order they were created.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// WriteText writes all metrics in the Prometheus text exposition format.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	return bw.Flush()
+}
+
+// ServeHTTP serves the metrics in text format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.WriteText(w)
+}
+
+func writeHeader(w *bufio.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+}
+
+// labelString formats label names and values as {a="x",b="y"}, with extra
+// appended as a last, already formatted pair.
+func labelString(names, values []string, extra string) string {
+	if len(names) == 0 && extra == "" {
+		return ""
+	}
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	var pairs []string
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, escape.Replace(values[i])))
+	}
+	if extra != "" {
+		pairs = append(pairs, extra)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Counter is a value that only goes up.
+type Counter struct {
+	v uint64
+}
+
+func (c *Counter) Inc() {
+	atomic.AddUint64(&c.v, 1)
+}
+
+func (c *Counter) Add(n uint64) {
+	atomic.AddUint64(&c.v, n)
+}
+
+func (c *Counter) Value() uint64 {
+	return atomic.LoadUint64(&c.v)
+}
+
+// vec holds one child metric per combination of label values.
+type vec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu       sync.Mutex
+	children map[string][]string
+}
+
+// key returns the map key for values, registering them on first use.
+func (v *vec) key(values []string) string {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s has %d labels, got %d values", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	if _, ok := v.children[key]; !ok {
+		v.children[key] = append([]string(nil), values...)
+	}
+	return key
+}
+
+// sortedKeys returns the keys of the children in order of their label
+// values.
+func (v *vec) sortedKeys() []string {
+	keys := make([]string, 0, len(v.children))
+	for k := range v.children {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct {
+	vec
+	counters map[string]*Counter
+}
+
+// With returns the counter for the given label values, in the order the
+// labels were declared.
+func (c *CounterVec) With(values ...string) *Counter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := c.key(values)
+	counter, ok := c.counters[key]
+	if !ok {
+		counter = &Counter{}
+		c.counters[key] = counter
+	}
+	return counter
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	writeHeader(w, c.name, c.help, "counter")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range c.sortedKeys() {
+		fmt.Fprintf(w, "%s%s %d\n", c.name, labelString(c.labels, c.children[key], ""), c.counters[key].Value())
+	}
+}
+
+// NewCounter registers a counter without labels.
+func (r *Registry) NewCounter(name, help string) *Counter {
+	return r.NewCounterVec(name, help).With()
+}
+
+// NewCounterVec registers a counter partitioned by the given labels.
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		vec:      vec{name: name, help: help, labels: labels, children: make(map[string][]string)},
+		counters: make(map[string]*Counter),
+	}
+	r.register(c)
+	return c
+}
+
+// Histogram counts observations in buckets with the given upper bounds.
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &Histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.buckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *Histogram) writeSamples(w *bufio.Writer, name string, labels, values []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.buckets {
+		le := fmt.Sprintf(`le="%s"`, formatFloat(bound))
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, labelString(labels, values, le), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket%s %d\n", name, labelString(labels, values, `le="+Inf"`), h.count)
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, labelString(labels, values, ""), formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labelString(labels, values, ""), h.count)
+}
+
+type histogramFamily struct {
+	name string
+	help string
+	h    *Histogram
+}
+
+func (f *histogramFamily) write(w *bufio.Writer) {
+	writeHeader(w, f.name, f.help, "histogram")
+	f.h.writeSamples(w, f.name, nil, nil)
+}
+
+// NewHistogram registers a histogram with the given bucket upper bounds.
+func (r *Registry) NewHistogram(name, help string, buckets []float64) *Histogram {
+	h := newHistogram(buckets)
+	r.register(&histogramFamily{name: name, help: help, h: h})
+	return h
+}
+
+// valueFunc is a gauge or counter whose values are computed when the
+// metrics are written.
+type valueFunc struct {
+	name  string
+	help  string
+	typ   string
+	label string
+	fn    func() map[string]float64
+}
+
+func (g *valueFunc) write(w *bufio.Writer) {
+	writeHeader(w, g.name, g.help, g.typ)
+	values := g.fn()
+	if g.label == "" {
+		fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(values[""]))
+		return
+	}
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, labelString([]string{g.label}, []string{k}, ""), formatFloat(values[k]))
+	}
+}
+
+// NewGaugeFunc registers a gauge whose value is fn's result.
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(&valueFunc{name: name, help: help, typ: "gauge", fn: func() map[string]float64 {
+		return map[string]float64{"": fn()}
+	}})
+}
+
+// NewGaugeVecFunc registers a gauge with one label whose values, by label
+// value, are fn's result.
+func (r *Registry) NewGaugeVecFunc(name, help, label string, fn func() map[string]float64) {
+	r.register(&valueFunc{name: name, help: help, typ: "gauge", label: label, fn: fn})
+}
+
+// NewCounterVecFunc registers a counter with one label whose values, by
+// label value, are fn's result. fn must never return a smaller value for a
+// label than before.
+func (r *Registry) NewCounterVecFunc(name, help, label string, fn func() map[string]float64) {
+	r.register(&valueFunc{name: name, help: help, typ: "counter", label: label, fn: fn})
+}
@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+	frames := r.NewCounterVec("frames_total", "Frames seen.", "type")
+	frames.With("NAK").Add(2)
+	frames.With("ACK").Inc()
+	errors := r.NewCounter("errors_total", "Errors.")
+	errors.Inc()
+	latency := r.NewHistogram("latency_seconds", "Latency.", []float64{0.1, 1})
+	latency.Observe(0.05)
+	latency.Observe(0.5)
+	latency.Observe(5)
+	r.NewGaugeFunc("depth", "Depth.", func() float64 { return 3 })
+	r.NewGaugeVecFunc("wait", "Wait.", "priority", func() map[string]float64 {
+		return map[string]float64{"user": 1.5, "poll": 0}
+	})
+	r.NewCounterVecFunc("waited_seconds_total", "Waited.", "priority", func() map[string]float64 {
+		return map[string]float64{"user": 2.5}
+	})
+
+	var buf bytes.Buffer
+	assert.NoError(t, r.WriteText(&buf))
+	assert.Equal(t, strings.Join([]string{
+		"# HELP frames_total Frames seen.",
+		"# TYPE frames_total counter",
+		`frames_total{type="ACK"} 1`,
+		`frames_total{type="NAK"} 2`,
+		"# HELP errors_total Errors.",
+		"# TYPE errors_total counter",
+		"errors_total 1",
+		"# HELP latency_seconds Latency.",
+		"# TYPE latency_seconds histogram",
+		`latency_seconds_bucket{le="0.1"} 1`,
+		`latency_seconds_bucket{le="1"} 2`,
+		`latency_seconds_bucket{le="+Inf"} 3`,
+		"latency_seconds_sum 5.55",
+		"latency_seconds_count 3",
+		"# HELP depth Depth.",
+		"# TYPE depth gauge",
+		"depth 3",
+		"# HELP wait Wait.",
+		"# TYPE wait gauge",
+		`wait{priority="poll"} 0`,
+		`wait{priority="user"} 1.5`,
+		"# HELP waited_seconds_total Waited.",
+		"# TYPE waited_seconds_total counter",
+		`waited_seconds_total{priority="user"} 2.5`,
+		"",
+	}, "\n"), buf.String())
+}
+
+func TestLabelEscaping(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounterVec("c", "Help with \\ and\nnewline.", "l").With("a\"b\\c").Inc()
+	var buf bytes.Buffer
+	assert.NoError(t, r.WriteText(&buf))
+	assert.Contains(t, buf.String(), `# HELP c Help with \\ and\nnewline.`)
+	assert.Contains(t, buf.String(), `c{l="a\"b\\c"} 1`)
+}
+
+func TestServeHTTP(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("up", "Up.").Inc()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, "text/plain; version=0.0.4", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), "up 1\n")
+}
+
+func TestWrongLabelCount(t *testing.T) {
+	r := NewRegistry()
+	assert.Panics(t, func() { r.NewCounterVec("c", "C.", "a", "b").With("x") })
+}
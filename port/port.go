@@ -0,0 +1,116 @@
+// Package port provides the byte streams a controller talks to a Z-Wave stick
+// over. Ports are opened by name, and the URL scheme of the name selects how:
+// serial devices, raw TCP serial servers such as ser2net and RFC 2217 servers
+// are built in, and Register adds others.
+package port
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/tarm/serial"
+)
+
+// Port is a byte stream to a Z-Wave stick. Ports may additionally implement
+// Flusher, ModemController, and transport.ReadDeadliner to bound the wait for
+// a frame.
+//
+// Read may return no bytes and no error when no data arrived for a while; any
+// error from Read other than an expired read deadline means the stick is gone.
+type Port interface {
+	Read(b []byte) (int, error)
+	Write(b []byte) (int, error)
+	Close() error
+}
+
+// Flusher discards data received but not read and data written but not
+// transmitted.
+type Flusher interface {
+	Flush() error
+}
+
+// ModemController drives the DTR and RTS lines of a serial port.
+type ModemController interface {
+	SetDTR(on bool) error
+	SetRTS(on bool) error
+}
+
+// ErrUnsupported is returned when a port lacks an optional capability.
+var ErrUnsupported = errors.New("operation not supported by port")
+
+// Opener opens the port described by config.
+type Opener func(config *serial.Config) (Port, error)
+
+var (
+	mu      sync.RWMutex
+	openers = map[string]Opener{}
+)
+
+// Register makes an Opener available for port names with the given URL
+// scheme. Registering a scheme twice replaces the earlier Opener.
+func Register(scheme string, open Opener) {
+	mu.Lock()
+	defer mu.Unlock()
+	openers[scheme] = open
+}
+
+func init() {
+	Register("serial", OpenSerial)
+	Register("tcp", func(config *serial.Config) (Port, error) {
+		u, err := url.Parse(config.Name)
+		if err != nil {
+			return nil, err
+		}
+		return DialTCP(u.Host)
+	})
+	Register("rfc2217", func(config *serial.Config) (Port, error) {
+		u, err := url.Parse(config.Name)
+		if err != nil {
+			return nil, err
+		}
+		return DialRFC2217(u.Host, config)
+	})
+}
+
+// Open opens config.Name with the Opener registered for its URL scheme, for
+// example tcp://host:port. A name without a scheme is a serial device.
+func Open(config *serial.Config) (Port, error) {
+	scheme := "serial"
+	if i := strings.Index(config.Name, "://"); i >= 0 {
+		scheme = config.Name[:i]
+	}
+	mu.RLock()
+	open, ok := openers[scheme]
+	mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unsupported port scheme %q", scheme)
+	}
+	return open(config)
+}
+
+// Flush flushes p if it is a Flusher.
+func Flush(p Port) error {
+	if f, ok := p.(Flusher); ok {
+		return f.Flush()
+	}
+	return ErrUnsupported
+}
+
+// SetDTR sets the DTR line of p if it is a ModemController.
+func SetDTR(p Port, on bool) error {
+	if m, ok := p.(ModemController); ok {
+		return m.SetDTR(on)
+	}
+	return ErrUnsupported
+}
+
+// SetRTS sets the RTS line of p if it is a ModemController.
+func SetRTS(p Port, on bool) error {
+	if m, ok := p.(ModemController); ok {
+		return m.SetRTS(on)
+	}
+	return ErrUnsupported
+}
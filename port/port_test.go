@@ -0,0 +1,73 @@
+package port
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tarm/serial"
+)
+
+type memoryPort struct {
+	net.Conn
+	flushed bool
+	dtr     bool
+}
+
+func (p *memoryPort) Flush() error {
+	p.flushed = true
+	return nil
+}
+
+func (p *memoryPort) SetDTR(on bool) error {
+	p.dtr = on
+	return nil
+}
+
+func (p *memoryPort) SetRTS(on bool) error {
+	return nil
+}
+
+func TestOpenRegisteredScheme(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	Register("memory", func(config *serial.Config) (Port, error) {
+		assert.Equal(t, "memory://stick", config.Name)
+		return &memoryPort{Conn: client}, nil
+	})
+
+	p, err := Open(&serial.Config{Name: "memory://stick"})
+	assert.NoError(t, err)
+	defer p.Close()
+
+	assert.NoError(t, Flush(p))
+	assert.True(t, p.(*memoryPort).flushed)
+	assert.NoError(t, SetDTR(p, true))
+	assert.True(t, p.(*memoryPort).dtr)
+	assert.NoError(t, SetRTS(p, true))
+}
+
+func TestOpenTCPScheme(t *testing.T) {
+	l := listen(t)
+	defer l.Close()
+
+	p, err := Open(&serial.Config{Name: "tcp://" + l.Addr().String()})
+	assert.NoError(t, err)
+	assert.IsType(t, &TCP{}, p)
+	assert.NoError(t, p.Close())
+}
+
+func TestOpenUnsupportedScheme(t *testing.T) {
+	_, err := Open(&serial.Config{Name: "udp://localhost:1"})
+	assert.ErrorContains(t, err, `unsupported port scheme "udp"`)
+}
+
+func TestOptionalCapabilitiesUnsupported(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	assert.ErrorIs(t, Flush(client), ErrUnsupported)
+	assert.ErrorIs(t, SetDTR(client, true), ErrUnsupported)
+	assert.ErrorIs(t, SetRTS(client, true), ErrUnsupported)
+}
@@ -0,0 +1,366 @@
+package port
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/tarm/serial"
+)
+
+// Telnet commands and options used by RFC 854, 856, 858 and 2217.
+const (
+	telnetSE   = 240
+	telnetSB   = 250
+	telnetWILL = 251
+	telnetWONT = 252
+	telnetDO   = 253
+	telnetDONT = 254
+	telnetIAC  = 255
+
+	optionBinary          = 0
+	optionSuppressGoAhead = 3
+	optionComPort         = 44
+)
+
+// COM-PORT-OPTION subnegotiation commands. The server answers each with the
+// same command plus comPortServerOffset.
+const (
+	comPortSetBaudRate  = 1
+	comPortSetDataSize  = 2
+	comPortSetParity    = 3
+	comPortSetStopSize  = 4
+	comPortSetControl   = 5
+	comPortServerOffset = 100
+)
+
+// SET-CONTROL values for the modem control lines.
+const (
+	controlDTROn  = 8
+	controlDTROff = 9
+	controlRTSOn  = 11
+	controlRTSOff = 12
+)
+
+// DefaultNegotiationTimeout bounds how long DialRFC2217 waits for the server
+// to acknowledge the port settings.
+const DefaultNegotiationTimeout = 5 * time.Second
+
+type telnetState int
+
+const (
+	telnetData telnetState = iota
+	telnetCommand
+	telnetOption
+	telnetSubnegotiation
+	telnetSubnegotiationIAC
+)
+
+// RFC2217 is a serial port reached through a Telnet COM Port Control (RFC
+// 2217) server. The baud rate, data size, parity and stop bits of the remote
+// port are set from a serial.Config, IAC bytes are escaped on write, and
+// Telnet commands are removed from the stream on read. RFC2217 is a
+// ModemController.
+type RFC2217 struct {
+	conn    net.Conn
+	writeMu sync.Mutex
+
+	raw    []byte
+	data   []byte
+	state  telnetState
+	verb   byte
+	sub    []byte
+	acked  map[byte][]byte
+	refuse bool
+	// adjusted holds the settings the server applied differently than
+	// requested, by command.
+	adjusted map[byte][]byte
+}
+
+// DialRFC2217 connects to the RFC 2217 server at addr (host:port) and
+// configures the remote port.
+func DialRFC2217(addr string, config *serial.Config) (*RFC2217, error) {
+	d := net.Dialer{Timeout: DefaultNegotiationTimeout, KeepAlive: DefaultKeepAlive}
+	conn, err := d.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	p, err := NewRFC2217(conn, config, DefaultNegotiationTimeout)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// NewRFC2217 negotiates COM port control over an established connection.
+func NewRFC2217(conn net.Conn, config *serial.Config, timeout time.Duration) (*RFC2217, error) {
+	p := &RFC2217{
+		conn:     conn,
+		raw:      make([]byte, 256),
+		acked:    make(map[byte][]byte),
+		adjusted: make(map[byte][]byte),
+	}
+	if err := p.negotiate(config, timeout); err != nil {
+		return nil, fmt.Errorf("RFC 2217 negotiation failed: %w", err)
+	}
+	return p, nil
+}
+
+func (p *RFC2217) negotiate(config *serial.Config, timeout time.Duration) error {
+	settings, err := comPortSettings(config)
+	if err != nil {
+		return err
+	}
+	msg := []byte{
+		telnetIAC, telnetWILL, optionComPort,
+		telnetIAC, telnetWILL, optionBinary,
+		telnetIAC, telnetDO, optionBinary,
+		telnetIAC, telnetWILL, optionSuppressGoAhead,
+		telnetIAC, telnetDO, optionSuppressGoAhead,
+	}
+	for _, s := range settings {
+		msg = append(msg, subnegotiation(s[0], s[1:])...)
+	}
+	if err := p.writeRaw(msg); err != nil {
+		return err
+	}
+
+	if err := p.conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	defer p.conn.SetReadDeadline(time.Time{})
+	for _, s := range settings {
+		for p.acked[s[0]+comPortServerOffset] == nil {
+			if p.refuse {
+				return fmt.Errorf("server refused COM-PORT-OPTION")
+			}
+			if err := p.fill(); err != nil {
+				return err
+			}
+		}
+		if got := p.acked[s[0]+comPortServerOffset]; string(got) != string(s[1:]) {
+			p.adjusted[s[0]] = got
+		}
+	}
+	return nil
+}
+
+// Adjusted returns the COM-PORT-OPTION settings the server acknowledged with
+// a different value than requested, by command (1 baud rate, 2 data size, 3
+// parity, 4 stop size). Some servers round the baud rate, for example.
+func (p *RFC2217) Adjusted() map[byte][]byte {
+	return p.adjusted
+}
+
+// comPortSettings returns the subnegotiation commands, each prefixed with
+// its command byte, that apply config to the remote port.
+func comPortSettings(config *serial.Config) ([][]byte, error) {
+	baud := make([]byte, 5)
+	baud[0] = comPortSetBaudRate
+	binary.BigEndian.PutUint32(baud[1:], uint32(config.Baud))
+
+	size := config.Size
+	if size == 0 {
+		size = serial.DefaultSize
+	}
+
+	var parity byte
+	switch config.Parity {
+	case serial.ParityNone, 0:
+		parity = 1
+	case serial.ParityOdd:
+		parity = 2
+	case serial.ParityEven:
+		parity = 3
+	case serial.ParityMark:
+		parity = 4
+	case serial.ParitySpace:
+		parity = 5
+	default:
+		return nil, fmt.Errorf("unsupported parity %q", config.Parity)
+	}
+
+	var stop byte
+	switch config.StopBits {
+	case serial.Stop1, 0:
+		stop = 1
+	case serial.Stop2:
+		stop = 2
+	case serial.Stop1Half:
+		stop = 3
+	default:
+		return nil, fmt.Errorf("unsupported stop bits %d", config.StopBits)
+	}
+
+	return [][]byte{
+		baud,
+		{comPortSetDataSize, size},
+		{comPortSetParity, parity},
+		{comPortSetStopSize, stop},
+	}, nil
+}
+
+func subnegotiation(command byte, value []byte) []byte {
+	b := []byte{telnetIAC, telnetSB, optionComPort, command}
+	b = append(b, escapeIAC(value)...)
+	return append(b, telnetIAC, telnetSE)
+}
+
+func escapeIAC(b []byte) []byte {
+	escaped := make([]byte, 0, len(b))
+	for _, c := range b {
+		escaped = append(escaped, c)
+		if c == telnetIAC {
+			escaped = append(escaped, telnetIAC)
+		}
+	}
+	return escaped
+}
+
+func (p *RFC2217) writeRaw(b []byte) error {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	_, err := p.conn.Write(b)
+	return err
+}
+
+// fill reads once from the connection and decodes what arrived.
+func (p *RFC2217) fill() error {
+	n, err := p.conn.Read(p.raw)
+	for _, c := range p.raw[:n] {
+		p.decode(c)
+	}
+	return err
+}
+
+func (p *RFC2217) decode(c byte) {
+	switch p.state {
+	case telnetData:
+		if c == telnetIAC {
+			p.state = telnetCommand
+		} else {
+			p.data = append(p.data, c)
+		}
+	case telnetCommand:
+		switch c {
+		case telnetIAC:
+			p.data = append(p.data, c)
+			p.state = telnetData
+		case telnetWILL, telnetWONT, telnetDO, telnetDONT:
+			p.verb = c
+			p.state = telnetOption
+		case telnetSB:
+			p.sub = p.sub[:0]
+			p.state = telnetSubnegotiation
+		default:
+			// NOP, GA and friends carry no meaning on a serial stream
+			p.state = telnetData
+		}
+	case telnetOption:
+		p.option(p.verb, c)
+		p.state = telnetData
+	case telnetSubnegotiation:
+		if c == telnetIAC {
+			p.state = telnetSubnegotiationIAC
+		} else {
+			p.sub = append(p.sub, c)
+		}
+	case telnetSubnegotiationIAC:
+		switch c {
+		case telnetSE:
+			p.subnegotiated(p.sub)
+			p.state = telnetData
+		default:
+			p.sub = append(p.sub, c)
+			p.state = telnetSubnegotiation
+		}
+	}
+}
+
+// option answers the server's option requests, refusing anything other than
+// the options offered during negotiation.
+func (p *RFC2217) option(verb, option byte) {
+	switch verb {
+	case telnetDO:
+		switch option {
+		case optionBinary, optionSuppressGoAhead, optionComPort:
+		default:
+			p.writeRaw([]byte{telnetIAC, telnetWONT, option})
+		}
+	case telnetWILL:
+		switch option {
+		case optionBinary, optionSuppressGoAhead:
+		default:
+			p.writeRaw([]byte{telnetIAC, telnetDONT, option})
+		}
+	case telnetDONT:
+		if option == optionComPort {
+			p.refuse = true
+		}
+	}
+}
+
+func (p *RFC2217) subnegotiated(sub []byte) {
+	if len(sub) < 2 || sub[0] != optionComPort {
+		return
+	}
+	value := make([]byte, len(sub)-2)
+	copy(value, sub[2:])
+	p.acked[sub[1]] = value
+}
+
+// Read returns data bytes from the remote port with Telnet commands removed.
+func (p *RFC2217) Read(b []byte) (int, error) {
+	for len(p.data) == 0 {
+		if err := p.fill(); err != nil {
+			if len(p.data) > 0 {
+				break
+			}
+			return 0, err
+		}
+	}
+	n := copy(b, p.data)
+	p.data = p.data[n:]
+	return n, nil
+}
+
+// Write sends b to the remote port, escaping IAC bytes.
+func (p *RFC2217) Write(b []byte) (int, error) {
+	if err := p.writeRaw(escapeIAC(b)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// SetDTR asks the server to raise or drop the DTR line of the remote port.
+func (p *RFC2217) SetDTR(on bool) error {
+	if on {
+		return p.setControl(controlDTROn)
+	}
+	return p.setControl(controlDTROff)
+}
+
+// SetRTS asks the server to raise or drop the RTS line of the remote port.
+func (p *RFC2217) SetRTS(on bool) error {
+	if on {
+		return p.setControl(controlRTSOn)
+	}
+	return p.setControl(controlRTSOff)
+}
+
+// setControl sends a SET-CONTROL command. The server's acknowledgement is
+// consumed by Read like any other subnegotiation.
+func (p *RFC2217) setControl(value byte) error {
+	return p.writeRaw(subnegotiation(comPortSetControl, []byte{value}))
+}
+
+func (p *RFC2217) SetReadDeadline(t time.Time) error {
+	return p.conn.SetReadDeadline(t)
+}
+
+func (p *RFC2217) Close() error {
+	return p.conn.Close()
+}
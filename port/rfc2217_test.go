@@ -0,0 +1,172 @@
+package port
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tarm/serial"
+)
+
+// rfc2217Server is a minimal RFC 2217 stand-in. It accepts COM-PORT-OPTION,
+// acknowledges every setting and collects the data bytes it receives.
+type rfc2217Server struct {
+	conn     net.Conn
+	refuse   bool
+	silent   bool
+	settings chan [2][]byte
+	data     chan byte
+	options  chan [2]byte
+}
+
+func newRFC2217Server(t *testing.T, refuse, silent bool) (*rfc2217Server, string) {
+	l := listen(t)
+	s := &rfc2217Server{
+		refuse:   refuse,
+		silent:   silent,
+		settings: make(chan [2][]byte, 10),
+		data:     make(chan byte, 100),
+		options:  make(chan [2]byte, 10),
+	}
+	ready := make(chan bool)
+	go func() {
+		defer l.Close()
+		ready <- true
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		s.conn = conn
+		s.serve(bufio.NewReader(conn))
+	}()
+	<-ready
+	return s, l.Addr().String()
+}
+
+func (s *rfc2217Server) serve(r *bufio.Reader) {
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		if c != telnetIAC {
+			s.data <- c
+			continue
+		}
+		verb, _ := r.ReadByte()
+		switch verb {
+		case telnetIAC:
+			s.data <- telnetIAC
+		case telnetWILL, telnetWONT, telnetDO, telnetDONT:
+			option, _ := r.ReadByte()
+			s.options <- [2]byte{verb, option}
+			if verb == telnetWILL && option == optionComPort && !s.silent {
+				if s.refuse {
+					s.conn.Write([]byte{telnetIAC, telnetDONT, optionComPort})
+				} else {
+					s.conn.Write([]byte{telnetIAC, telnetDO, optionComPort})
+				}
+			}
+		case telnetSB:
+			var sub []byte
+			for {
+				b, _ := r.ReadByte()
+				if b == telnetIAC {
+					b, _ = r.ReadByte()
+					if b == telnetSE {
+						break
+					}
+				}
+				sub = append(sub, b)
+			}
+			s.settings <- [2][]byte{{sub[1]}, sub[2:]}
+			if !s.silent && !s.refuse {
+				s.conn.Write(subnegotiation(sub[1]+comPortServerOffset, sub[2:]))
+			}
+		}
+	}
+}
+
+func testSerialConfig() *serial.Config {
+	return &serial.Config{Baud: 115200, Size: 8, Parity: serial.ParityNone, StopBits: serial.Stop1}
+}
+
+func TestRFC2217Negotiation(t *testing.T) {
+	s, addr := newRFC2217Server(t, false, false)
+	p, err := DialRFC2217(addr, testSerialConfig())
+	assert.NoError(t, err)
+	defer p.Close()
+
+	settings := map[byte][]byte{}
+	for i := 0; i < 4; i++ {
+		setting := <-s.settings
+		settings[setting[0][0]] = setting[1]
+	}
+	assert.Equal(t, map[byte][]byte{
+		comPortSetBaudRate: {0x00, 0x01, 0xc2, 0x00},
+		comPortSetDataSize: {8},
+		comPortSetParity:   {1},
+		comPortSetStopSize: {1},
+	}, settings)
+}
+
+func TestRFC2217EscapesIAC(t *testing.T) {
+	s, addr := newRFC2217Server(t, false, false)
+	p, err := DialRFC2217(addr, testSerialConfig())
+	assert.NoError(t, err)
+	defer p.Close()
+
+	n, err := p.Write([]byte{0x01, 0xff, 0x02})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, []byte{0x01, 0xff, 0x02}, []byte{<-s.data, <-s.data, <-s.data})
+
+	// escaped data interleaved with a NOP and an unsolicited option offer
+	_, err = s.conn.Write([]byte{0x06, telnetIAC, telnetIAC, telnetIAC, 241, 0x15, telnetIAC, telnetWILL, 1, 0x18})
+	assert.NoError(t, err)
+	got := make([]byte, 4)
+	_, err = io.ReadFull(p, got)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x06, 0xff, 0x15, 0x18}, got)
+
+	for option := range s.options {
+		if option[1] == 1 {
+			assert.Equal(t, [2]byte{telnetDONT, 1}, option)
+			break
+		}
+	}
+}
+
+func TestRFC2217ModemControl(t *testing.T) {
+	s, addr := newRFC2217Server(t, false, false)
+	p, err := DialRFC2217(addr, testSerialConfig())
+	assert.NoError(t, err)
+	defer p.Close()
+	for i := 0; i < 4; i++ {
+		<-s.settings
+	}
+
+	var port Port = p
+	assert.NoError(t, SetDTR(port, true))
+	assert.NoError(t, SetRTS(port, false))
+	assert.Equal(t, [2][]byte{{comPortSetControl}, {controlDTROn}}, <-s.settings)
+	assert.Equal(t, [2][]byte{{comPortSetControl}, {controlRTSOff}}, <-s.settings)
+}
+
+func TestRFC2217Refused(t *testing.T) {
+	_, addr := newRFC2217Server(t, true, false)
+	_, err := DialRFC2217(addr, testSerialConfig())
+	assert.ErrorContains(t, err, "server refused COM-PORT-OPTION")
+}
+
+func TestRFC2217NegotiationTimeout(t *testing.T) {
+	_, addr := newRFC2217Server(t, false, true)
+	conn, err := net.Dial("tcp", addr)
+	assert.NoError(t, err)
+	defer conn.Close()
+	_, err = NewRFC2217(conn, testSerialConfig(), 20*time.Millisecond)
+	assert.ErrorContains(t, err, "RFC 2217 negotiation failed")
+}
@@ -0,0 +1,71 @@
+package port
+
+import (
+	"io"
+	"strings"
+	"time"
+
+	"github.com/tarm/serial"
+)
+
+// Serial is a local serial device. It is not a ModemController:
+// tarm/serial gives no access to the DTR and RTS lines.
+type Serial struct {
+	port *serial.Port
+	// idle is how long a read lasts when the read timeout expires without
+	// data, or zero if reads block until data arrives.
+	idle time.Duration
+}
+
+// OpenSerial opens a local serial device. A serial:// prefix on the name is
+// ignored.
+func OpenSerial(config *serial.Config) (Port, error) {
+	c := *config
+	c.Name = strings.TrimPrefix(c.Name, "serial://")
+	p, err := serial.OpenPort(&c)
+	if err != nil {
+		return nil, err
+	}
+	return &Serial{port: p, idle: idleTimeout(c.ReadTimeout)}, nil
+}
+
+// idleTimeout is the read timeout the device actually applies for
+// readTimeout: whole tenths of a second between 0.1s and 25.5s.
+func idleTimeout(readTimeout time.Duration) time.Duration {
+	if readTimeout <= 0 {
+		return 0
+	}
+	t := readTimeout.Truncate(100 * time.Millisecond)
+	if t < 100*time.Millisecond {
+		t = 100 * time.Millisecond
+	} else if t > 25500*time.Millisecond {
+		t = 25500 * time.Millisecond
+	}
+	return t
+}
+
+// Read returns no bytes and no error when the read timeout expires without
+// data. tarm/serial reports that as io.EOF on Linux, like a hangup, so an
+// io.EOF is only passed on if it came well before the timeout.
+func (s *Serial) Read(b []byte) (int, error) {
+	start := time.Now()
+	n, err := s.port.Read(b)
+	if n == 0 && err == io.EOF && s.idle > 0 && time.Since(start) >= s.idle/2 {
+		return 0, nil
+	}
+	return n, err
+}
+
+func (s *Serial) Write(b []byte) (int, error) {
+	return s.port.Write(b)
+}
+
+// Flush discards data received but not read and data written but not
+// transmitted.
+func (s *Serial) Flush() error {
+	return s.port.Flush()
+}
+
+func (s *Serial) Close() error {
+	return s.port.Close()
+}
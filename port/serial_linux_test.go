@@ -0,0 +1,77 @@
+package port
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tarm/serial"
+)
+
+// openPTY opens a pseudo-terminal and returns its master and the name of its
+// slave device.
+func openPTY(t *testing.T) (*os.File, string) {
+	t.Helper()
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("no pseudo-terminals: %s", err)
+	}
+	var unlock int32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); errno != 0 {
+		master.Close()
+		t.Fatal(errno)
+	}
+	var n uint32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); errno != 0 {
+		master.Close()
+		t.Fatal(errno)
+	}
+	return master, fmt.Sprintf("/dev/pts/%d", n)
+}
+
+func TestSerialIdleTimeout(t *testing.T) {
+	master, name := openPTY(t)
+	defer master.Close()
+	p, err := OpenSerial(&serial.Config{Name: "serial://" + name, Baud: 115200, ReadTimeout: 100 * time.Millisecond})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer p.Close()
+	assert.NoError(t, Flush(p))
+
+	buf := make([]byte, 16)
+	n, err := p.Read(buf)
+	assert.NoError(t, err, "a read that times out is not an error")
+	assert.Equal(t, 0, n)
+
+	_, err = master.Write([]byte{0x06})
+	assert.NoError(t, err)
+	n, err = p.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x06}, buf[:n])
+}
+
+func TestSerialHangup(t *testing.T) {
+	master, name := openPTY(t)
+	p, err := OpenSerial(&serial.Config{Name: name, Baud: 115200, ReadTimeout: 100 * time.Millisecond})
+	if !assert.NoError(t, err) {
+		master.Close()
+		return
+	}
+	defer p.Close()
+
+	master.Close()
+	_, err = p.Read(make([]byte, 16))
+	assert.Error(t, err)
+}
+
+func TestIdleTimeout(t *testing.T) {
+	assert.Equal(t, time.Duration(0), idleTimeout(0))
+	assert.Equal(t, 100*time.Millisecond, idleTimeout(time.Millisecond))
+	assert.Equal(t, 1500*time.Millisecond, idleTimeout(1550*time.Millisecond))
+	assert.Equal(t, 25500*time.Millisecond, idleTimeout(time.Minute))
+}
@@ -0,0 +1,30 @@
+package port
+
+import (
+	"net"
+	"time"
+)
+
+const (
+	DefaultKeepAlive   = 15 * time.Second
+	DefaultDialTimeout = 10 * time.Second
+)
+
+// TCP is a connection to a raw TCP serial server such as ser2net. Keepalives
+// detect a server that went away. A dropped connection fails Read and Write
+// like an unplugged stick; the controller reconnects by opening the port
+// again.
+type TCP struct {
+	net.Conn
+	Addr string
+}
+
+// DialTCP connects to the serial server at addr (host:port).
+func DialTCP(addr string) (*TCP, error) {
+	d := net.Dialer{Timeout: DefaultDialTimeout, KeepAlive: DefaultKeepAlive}
+	conn, err := d.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &TCP{Conn: conn, Addr: addr}, nil
+}
@@ -0,0 +1,96 @@
+package port
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func listen(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestTCPReadWrite(t *testing.T) {
+	l := listen(t)
+	defer l.Close()
+
+	p, err := DialTCP(l.Addr().String())
+	assert.NoError(t, err)
+	defer p.Close()
+	server, err := l.Accept()
+	assert.NoError(t, err)
+	defer server.Close()
+
+	_, err = p.Write([]byte{0x06})
+	assert.NoError(t, err)
+	b := make([]byte, 1)
+	_, err = io.ReadFull(server, b)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x06}, b)
+
+	_, err = server.Write([]byte{0x15})
+	assert.NoError(t, err)
+	_, err = io.ReadFull(p, b)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x15}, b)
+}
+
+func TestTCPDropFailsRead(t *testing.T) {
+	l := listen(t)
+	defer l.Close()
+
+	p, err := DialTCP(l.Addr().String())
+	assert.NoError(t, err)
+	defer p.Close()
+
+	server, err := l.Accept()
+	assert.NoError(t, err)
+	server.Write([]byte{0x01})
+	server.Close()
+
+	b := make([]byte, 1)
+	_, err = io.ReadFull(p, b)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x01}, b)
+	_, err = p.Read(b)
+	assert.ErrorIs(t, err, io.EOF, "the port does not reconnect by itself")
+}
+
+func TestTCPReadDeadline(t *testing.T) {
+	l := listen(t)
+	defer l.Close()
+
+	p, err := DialTCP(l.Addr().String())
+	assert.NoError(t, err)
+	defer p.Close()
+
+	assert.NoError(t, p.SetReadDeadline(time.Now().Add(10*time.Millisecond)))
+	_, err = p.Read(make([]byte, 1))
+	assert.True(t, errors.Is(err, os.ErrDeadlineExceeded))
+}
+
+func TestTCPCloseUnblocksRead(t *testing.T) {
+	l := listen(t)
+	defer l.Close()
+
+	p, err := DialTCP(l.Addr().String())
+	assert.NoError(t, err)
+
+	done := make(chan error)
+	go func() {
+		_, err := p.Read(make([]byte, 1))
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, p.Close())
+	assert.ErrorIs(t, <-done, net.ErrClosed)
+}
@@ -1,10 +1,13 @@
 package transport
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"os"
+	"time"
 
-	log "github.com/sirupsen/logrus"
+	"github.com/jbielick/zwgo/logging"
 )
 
 //go:generate stringer -type=state
@@ -18,27 +21,97 @@ const (
 	stateEndPayload
 )
 
+// minDataFrameLength is the smallest length byte a data frame can carry: the
+// length byte itself, the data frame type and a function ID.
+const minDataFrameLength = 3
+
+// DefaultFrameTimeout is the receive timeout for a data frame recommended by
+// the Serial API host specification, measured from the SOF byte.
+const DefaultFrameTimeout = 1500 * time.Millisecond
+
+// CorruptFrameError is returned by Decoder.Next when a data frame was started
+// but could not be accepted. The decoder has already discarded the frame and
+// resynchronized; per the Serial API the host must answer it with a NAK.
+type CorruptFrameError struct {
+	Frame *Frame
+	Err   error
+}
+
+func (e *CorruptFrameError) Error() string {
+	return fmt.Sprintf("corrupt frame %s: %s", e.Frame, e.Err)
+}
+
+func (e *CorruptFrameError) Unwrap() error {
+	return e.Err
+}
+
+// ChecksumError reports a data frame whose checksum byte, Got, differs from
+// the checksum computed over the frame, Want.
+type ChecksumError struct {
+	Want byte
+	Got  byte
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("checksum did not match: want 0x%02x, got 0x%02x", e.Want, e.Got)
+}
+
+// FrameTimeoutError is returned by Decoder.Next when a data frame was not
+// completely received within the decoder's FrameTimeout. The partial frame has
+// been dropped.
+type FrameTimeoutError struct {
+	Frame   *Frame
+	Timeout time.Duration
+}
+
+func (e *FrameTimeoutError) Error() string {
+	return fmt.Sprintf("timed out after %s receiving frame %s", e.Timeout, e.Frame)
+}
+
+// ReadDeadliner is implemented by readers such as net.Conn and *os.File that
+// can interrupt a blocked Read. The Decoder uses it to enforce FrameTimeout.
+type ReadDeadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
 type Decoder struct {
-	r         io.Reader
-	state     state
-	buf       []byte
-	pos       int
-	have      int
-	dataFrame *Frame
+	// FrameTimeout bounds the time between the SOF byte and the checksum of a
+	// data frame. Zero disables the timeout.
+	FrameTimeout time.Duration
+	// Logger receives debug entries about skipped bytes. Nil logs nothing.
+	Logger logging.Logger
+
+	r            io.Reader
+	state        state
+	buf          []byte
+	pos          int
+	have         int
+	dataFrame    *Frame
+	discarded    int
+	deadline     time.Time
+	readDeadline time.Time
+	noDeadline   bool
 }
 
 func NewDecoder(r io.Reader) *Decoder {
-	return &Decoder{r: r, buf: make([]byte, 256)}
+	return &Decoder{
+		FrameTimeout: DefaultFrameTimeout,
+		r:            r,
+		buf:          make([]byte, 256),
+	}
 }
 
 func (d *Decoder) More() error {
+	if err := d.setReadDeadline(); err != nil {
+		return err
+	}
 	nBytes, err := d.r.Read(d.buf)
 	if err != nil {
+		if d.state != stateBeginFrame && errors.Is(err, os.ErrDeadlineExceeded) {
+			return d.timeout()
+		}
 		return err
 	}
-	if nBytes < 1 {
-		log.Warn("got 0 bytes when reading")
-	}
 	d.have = nBytes
 	return nil
 }
@@ -48,95 +121,175 @@ func (d *Decoder) More() error {
 // }
 
 func (d *Decoder) NextByte() (byte, error) {
-	if d.pos >= d.have {
+	// ports may return no bytes without an error, as port.Serial does when
+	// its read timeout expires, so a data frame that stalls on such a port is
+	// dropped here once its deadline has passed
+	for d.pos >= d.have {
 		err := d.More()
 		if err != nil {
 			return 0x00, err
 		}
 		d.pos = 0
+		if d.have == 0 && d.expired() {
+			return 0x00, d.timeout()
+		}
 	}
 	b := d.buf[d.pos]
 	d.pos++
 	return b, nil
 }
 
-func (d *Decoder) Next() (*Frame, error) {
-	b, err := d.NextByte()
-	if err != nil {
-		return nil, err
+// setReadDeadline arms the reader's deadline while a data frame is in
+// progress and clears it otherwise. Readers without deadline support are
+// checked against the frame deadline as bytes arrive instead.
+func (d *Decoder) setReadDeadline() error {
+	r, ok := d.r.(ReadDeadliner)
+	if !ok || d.noDeadline {
+		return nil
+	}
+	var t time.Time
+	if d.state != stateBeginFrame {
+		t = d.deadline
+	}
+	if t.Equal(d.readDeadline) {
+		return nil
+	}
+	if err := r.SetReadDeadline(t); err != nil {
+		if errors.Is(err, os.ErrNoDeadline) {
+			d.noDeadline = true
+			return nil
+		}
+		return err
 	}
+	d.readDeadline = t
+	return nil
+}
 
-	switch d.state {
-	case stateBeginFrame:
-		return d.stateBeginFrame(b)
-	case stateLength:
-		return d.stateLength(b)
-	case stateDataFrameType:
-		return d.stateDataFrameType(b)
-	case stateStartPayload:
-		return d.stateStartPayload(b)
-	case stateEndPayload:
-		return d.stateEndPayload(b)
-	default:
-		return nil, fmt.Errorf(
-			"decoder in unexpected state while decoding frame: state=%s frame=%s byte=%q",
-			d.state,
-			d.dataFrame,
-			b,
-		)
+// Discarded returns the number of bytes skipped so far while searching for
+// the start of a frame.
+func (d *Decoder) Discarded() int {
+	return d.discarded
+}
+
+// Next reads until a complete frame has been decoded. Bytes that cannot start
+// a frame are skipped. A data frame with an invalid length or checksum is
+// dropped and reported as a *CorruptFrameError; decoding can continue with the
+// next call.
+func (d *Decoder) Next() (*Frame, error) {
+	for {
+		b, err := d.NextByte()
+		if err != nil {
+			return nil, err
+		}
+		if d.expired() {
+			// leave the late byte for the next frame
+			d.pos--
+			return nil, d.timeout()
+		}
+
+		var frame *Frame
+		switch d.state {
+		case stateBeginFrame:
+			frame = d.stateBeginFrame(b)
+		case stateLength:
+			err = d.stateLength(b)
+		case stateDataFrameType:
+			d.stateDataFrameType(b)
+		case stateStartPayload:
+			d.stateStartPayload(b)
+		case stateEndPayload:
+			frame, err = d.stateEndPayload(b)
+		default:
+			err = fmt.Errorf(
+				"decoder in unexpected state while decoding frame: state=%s frame=%s byte=%q",
+				d.state,
+				d.dataFrame,
+				b,
+			)
+			d.reset()
+		}
+		if err != nil {
+			return nil, err
+		}
+		if frame != nil {
+			return frame, nil
+		}
 	}
 }
 
-func (d *Decoder) stateBeginFrame(b byte) (*Frame, error) {
+func (d *Decoder) reset() {
+	d.state = stateBeginFrame
+	d.dataFrame = nil
+	d.deadline = time.Time{}
+}
+
+// expired reports whether the data frame in progress is past its deadline.
+func (d *Decoder) expired() bool {
+	return d.state != stateBeginFrame && !d.deadline.IsZero() && time.Now().After(d.deadline)
+}
+
+// timeout drops the data frame in progress because it took too long to arrive.
+func (d *Decoder) timeout() error {
+	err := &FrameTimeoutError{Frame: d.dataFrame, Timeout: d.FrameTimeout}
+	d.reset()
+	return err
+}
+
+// abort drops the data frame in progress and returns the error describing why.
+func (d *Decoder) abort(reason error) error {
+	err := &CorruptFrameError{Frame: d.dataFrame, Err: reason}
+	d.reset()
+	return err
+}
+
+func (d *Decoder) stateBeginFrame(b byte) *Frame {
 	switch FrameType(b) {
 	case ACK:
-		return NewACK(), nil
+		return NewACK()
 	case NAK:
-		return NewNAK(), nil
+		return NewNAK()
 	case CAN:
-		return NewCAN(), nil
+		return NewCAN()
 	case SOF:
 		d.dataFrame = &Frame{FrameType: SOF}
 		d.state = stateLength
-		return d.Next()
+		if d.FrameTimeout > 0 {
+			d.deadline = time.Now().Add(d.FrameTimeout)
+		}
 	default:
-		return nil, fmt.Errorf("unrecognized frame type: %q", b)
+		d.discarded++
+		logging.OrNop(d.Logger).Log(logging.Debug, "skipping unrecognized frame type", logging.F("byte", fmt.Sprintf("0x%02x", b)))
 	}
+	return nil
 }
 
-func (d *Decoder) stateLength(b byte) (*Frame, error) {
+func (d *Decoder) stateLength(b byte) error {
 	d.dataFrame.Len = int(b)
+	if d.dataFrame.Len < minDataFrameLength {
+		return d.abort(fmt.Errorf("invalid length %d", b))
+	}
 	d.state = stateDataFrameType
-	return d.Next()
+	return nil
 }
 
-func (d *Decoder) stateDataFrameType(b byte) (*Frame, error) {
+func (d *Decoder) stateDataFrameType(b byte) {
 	d.dataFrame.SetDataFrameType(DataFrameType(b))
 	d.state = stateStartPayload
-	return d.Next()
 }
 
-func (d *Decoder) stateStartPayload(b byte) (*Frame, error) {
+func (d *Decoder) stateStartPayload(b byte) {
 	d.dataFrame.Payload = append(d.dataFrame.Payload, b)
 	if len(d.dataFrame.Payload) == d.dataFrame.Len-2 {
 		d.state = stateEndPayload
 	}
-	return d.Next()
 }
 
 func (d *Decoder) stateEndPayload(statedSum byte) (*Frame, error) {
 	calculatedSum := d.dataFrame.Checksum()
 	if statedSum != calculatedSum {
-		return d.dataFrame, fmt.Errorf(
-			"checksum did not match. have: %+q, want: %+q",
-			calculatedSum,
-			statedSum,
-		)
+		return nil, d.abort(&ChecksumError{Want: calculatedSum, Got: statedSum})
 	}
-	d.state = stateBeginFrame
 	dataFrame := d.dataFrame
-	d.dataFrame = nil
-	log.Printf("%#v %q", dataFrame, dataFrame.Checksum())
-
+	d.reset()
 	return dataFrame, nil
 }
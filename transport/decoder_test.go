@@ -2,8 +2,10 @@ package transport
 
 import (
 	"io"
+	"net"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -84,7 +86,20 @@ func TestDataFrameDecoding(t *testing.T) {
 func TestBadStartOfFrame(t *testing.T) {
 	d := NewDecoder(strings.NewReader("\x09"))
 	_, err := d.Next()
-	assert.ErrorContains(t, err, "unrecognized frame type")
+	assert.True(t, err == io.EOF)
+	assert.Equal(t, 1, d.Discarded())
+}
+
+func TestSkipsGarbageBeforeFrame(t *testing.T) {
+	d := NewDecoder(strings.NewReader("\x09\xff\x00\x06\x01\x03\x00\x15\xe9"))
+	got, err := d.Next()
+	assert.NoError(t, err)
+	assert.True(t, got.IsACK())
+	got, err = d.Next()
+	assert.NoError(t, err)
+	assert.True(t, got.IsRequest())
+	assert.Equal(t, []byte{0x15}, got.Payload)
+	assert.Equal(t, 3, d.Discarded())
 }
 
 func TestBadDataFrame(t *testing.T) {
@@ -93,8 +108,114 @@ func TestBadDataFrame(t *testing.T) {
 	assert.True(t, err == io.EOF)
 }
 
+func TestBadLength(t *testing.T) {
+	d := NewDecoder(strings.NewReader("\x01\x01\x06"))
+	_, err := d.Next()
+	var corrupt *CorruptFrameError
+	assert.ErrorAs(t, err, &corrupt)
+	assert.ErrorContains(t, err, "invalid length")
+
+	got, err := d.Next()
+	assert.NoError(t, err)
+	assert.True(t, got.IsACK())
+}
+
 func TestBadChecksum(t *testing.T) {
 	d := NewDecoder(strings.NewReader("\x01\x10\x01\x15Z-Wave 6.07\x00\x01\x92"))
 	_, err := d.Next()
 	assert.ErrorContains(t, err, "checksum did not match")
+	var corrupt *CorruptFrameError
+	assert.ErrorAs(t, err, &corrupt)
+	assert.Equal(t, []byte("\x15Z-Wave 6.07\x00\x01"), corrupt.Frame.Payload)
+	var checksum *ChecksumError
+	if assert.ErrorAs(t, err, &checksum) {
+		assert.Equal(t, byte(0x92), checksum.Got)
+		assert.Equal(t, byte(0x97), checksum.Want)
+	}
+}
+
+func TestResynchronizesAfterBadChecksum(t *testing.T) {
+	d := NewDecoder(strings.NewReader("\x01\x03\x00\x15\x00\x01\x03\x00\x15\xe9"))
+	_, err := d.Next()
+	var corrupt *CorruptFrameError
+	assert.ErrorAs(t, err, &corrupt)
+
+	got, err := d.Next()
+	assert.NoError(t, err)
+	assert.True(t, got.IsRequest())
+	assert.Equal(t, []byte{0x15}, got.Payload)
+}
+
+// slowReader yields one chunk per Read, sleeping before each one. It has no
+// deadline support.
+type slowReader struct {
+	chunks []string
+	delay  time.Duration
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	if len(r.chunks) == 0 {
+		return 0, io.EOF
+	}
+	time.Sleep(r.delay)
+	n := copy(p, r.chunks[0])
+	r.chunks = r.chunks[1:]
+	return n, nil
+}
+
+func TestFrameTimeoutWithDeadline(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	d := NewDecoder(client)
+	d.FrameTimeout = 20 * time.Millisecond
+	go func() {
+		server.Write([]byte("\x01\x03\x00"))
+		time.Sleep(50 * time.Millisecond)
+		server.Write([]byte("\x06"))
+	}()
+
+	_, err := d.Next()
+	var timeout *FrameTimeoutError
+	assert.ErrorAs(t, err, &timeout)
+	assert.Equal(t, stateBeginFrame, d.state)
+
+	got, err := d.Next()
+	assert.NoError(t, err)
+	assert.True(t, got.IsACK())
+}
+
+func TestFrameTimeoutWithoutDeadline(t *testing.T) {
+	d := NewDecoder(&slowReader{
+		chunks: []string{"\x01\x03", "\x06\x01\x03\x00\x15\xe9"},
+		delay:  30 * time.Millisecond,
+	})
+	d.FrameTimeout = 10 * time.Millisecond
+
+	_, err := d.Next()
+	var timeout *FrameTimeoutError
+	assert.ErrorAs(t, err, &timeout)
+
+	got, err := d.Next()
+	assert.NoError(t, err)
+	assert.True(t, got.IsACK())
+	got, err = d.Next()
+	assert.NoError(t, err)
+	assert.True(t, got.IsRequest())
+}
+
+func TestFrameTimeoutOnEmptyRead(t *testing.T) {
+	// the stream ends after the empty read, so only the timeout check on an
+	// empty read can report the stalled frame
+	d := NewDecoder(&slowReader{
+		chunks: []string{"\x01\x03", ""},
+		delay:  20 * time.Millisecond,
+	})
+	d.FrameTimeout = 10 * time.Millisecond
+
+	_, err := d.Next()
+	var timeout *FrameTimeoutError
+	assert.ErrorAs(t, err, &timeout)
+	assert.Equal(t, stateBeginFrame, d.state)
 }
@@ -0,0 +1,61 @@
+package transport
+
+import (
+	"io"
+	"sync"
+)
+
+// Encoder writes frames to an io.Writer. Each call to Encode writes exactly
+// one complete frame; concurrent callers never interleave their bytes.
+type Encoder struct {
+	w      io.Writer
+	mu     sync.Mutex
+	bytes  uint64
+	frames uint64
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode marshals f and writes it, retrying short writes until the whole
+// frame has been written or the writer fails. It returns the number of bytes
+// written.
+func (e *Encoder) Encode(f *Frame) (int, error) {
+	data, err := f.MarshalBinary()
+	if err != nil {
+		return 0, err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	written := 0
+	for written < len(data) {
+		n, err := e.w.Write(data[written:])
+		written += n
+		e.bytes += uint64(n)
+		if err != nil {
+			return written, err
+		}
+		if n == 0 {
+			return written, io.ErrShortWrite
+		}
+	}
+	e.frames++
+	return written, nil
+}
+
+// Bytes returns the number of bytes written so far.
+func (e *Encoder) Bytes() uint64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.bytes
+}
+
+// Frames returns the number of frames completely written so far.
+func (e *Encoder) Frames() uint64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.frames
+}
@@ -0,0 +1,107 @@
+package transport
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// shortWriter accepts at most max bytes per Write.
+type shortWriter struct {
+	bytes.Buffer
+	max    int
+	writes int
+}
+
+func (w *shortWriter) Write(p []byte) (int, error) {
+	w.writes++
+	if len(p) > w.max {
+		p = p[:w.max]
+	}
+	return w.Buffer.Write(p)
+}
+
+type failingWriter struct {
+	n int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	return w.n, errors.New("write failed")
+}
+
+type stalledWriter struct{}
+
+func (stalledWriter) Write(p []byte) (int, error) {
+	return 0, nil
+}
+
+func TestEncode(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+
+	n, err := e.Encode(NewRequest([]byte{0x15}))
+	assert.NoError(t, err)
+	assert.Equal(t, 5, n)
+	n, err = e.Encode(NewACK())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	assert.Equal(t, []byte("\x01\x03\x00\x15\xe9\x06"), buf.Bytes())
+	assert.Equal(t, uint64(6), e.Bytes())
+	assert.Equal(t, uint64(2), e.Frames())
+}
+
+func TestEncodeShortWrites(t *testing.T) {
+	w := &shortWriter{max: 2}
+	e := NewEncoder(w)
+
+	n, err := e.Encode(NewRequest([]byte{0x15}))
+	assert.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, 3, w.writes)
+	assert.Equal(t, []byte("\x01\x03\x00\x15\xe9"), w.Bytes())
+}
+
+func TestEncodeWriteError(t *testing.T) {
+	e := NewEncoder(&failingWriter{n: 2})
+
+	n, err := e.Encode(NewRequest([]byte{0x15}))
+	assert.EqualError(t, err, "write failed")
+	assert.Equal(t, 2, n)
+	assert.Equal(t, uint64(2), e.Bytes())
+	assert.Equal(t, uint64(0), e.Frames())
+}
+
+func TestEncodeStalledWriter(t *testing.T) {
+	e := NewEncoder(stalledWriter{})
+
+	_, err := e.Encode(NewACK())
+	assert.ErrorIs(t, err, io.ErrShortWrite)
+}
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	frames := []*Frame{
+		NewRequest([]byte{0x15}),
+		NewACK(),
+		NewResponse([]byte("\x15Z-Wave 6.07\x00\x01")),
+		NewNAK(),
+		NewCAN(),
+	}
+	for _, f := range frames {
+		_, err := e.Encode(f)
+		assert.NoError(t, err)
+	}
+
+	d := NewDecoder(&buf)
+	for _, want := range frames {
+		got, err := d.Next()
+		assert.NoError(t, err)
+		assert.Equal(t, want.FrameType, got.FrameType)
+		assert.Equal(t, want.Payload, got.Payload)
+	}
+}
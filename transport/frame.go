@@ -1,9 +1,7 @@
 package transport
 
 import (
-	"encoding"
 	"fmt"
-	"log"
 )
 
 //go:generate stringer -type=FrameType
@@ -28,7 +26,7 @@ type Frame struct {
 	FrameType     FrameType
 	Len           int
 	DataFrameType *DataFrameType
-	Payload       encoding.BinaryMarshaler
+	Payload       []byte
 }
 
 func (f *Frame) Length() int {
@@ -36,7 +34,7 @@ func (f *Frame) Length() int {
 	length := 1
 	if f.IsDataFrame() {
 		length += 1
-		length += len(binary.Marshal(f.Payload))
+		length += len(f.Payload)
 	}
 	return length
 }
@@ -64,21 +62,25 @@ func (f *Frame) MarshalBinary() ([]byte, error) {
 }
 
 func (f *Frame) UnmarshalBinary(data []byte) error {
+	if len(data) < 1 {
+		return fmt.Errorf("Failed to unmarshal frame, no data")
+	}
 	f.FrameType = FrameType(data[0])
 	if !f.IsDataFrame() {
 		return nil
 	}
+	if len(data) < 2+minDataFrameLength {
+		return fmt.Errorf("Failed to unmarshal frame, data frame too short: % x", data)
+	}
 	DataFrameType := DataFrameType(data[2])
 
 	f.DataFrameType = &DataFrameType
 	f.Payload = data[3 : len(data)-1]
-	log.Print(f)
-	log.Print(f.Length())
 
 	checksum := data[len(data)-1]
 	calculatedChecksum := f.Checksum()
 	if checksum != calculatedChecksum {
-		return fmt.Errorf("Failed to unmarshal frame, checksum did not match. Want: %+q, Got: %+q", checksum, calculatedChecksum)
+		return &ChecksumError{Want: calculatedChecksum, Got: checksum}
 	}
 
 	return nil
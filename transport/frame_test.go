@@ -83,6 +83,15 @@ func TestMarshalBinary(t *testing.T) {
 	}
 }
 
+func TestUnmarshalBinaryChecksumError(t *testing.T) {
+	err := new(Frame).UnmarshalBinary([]byte("\x01\x03\x00\x15\xe8"))
+	var checksum *ChecksumError
+	if assert.ErrorAs(t, err, &checksum) {
+		assert.Equal(t, byte(0xe9), checksum.Want)
+		assert.Equal(t, byte(0xe8), checksum.Got)
+	}
+}
+
 func TestFrameIsACK(t *testing.T) {
 	f := NewACK()
 	assert.Equal(t, true, f.IsACK())
@@ -137,3 +146,15 @@ func BenchmarkChecksumLongFrame(b *testing.B) {
 		frame.Checksum()
 	}
 }
+
+func TestFrameFunctionID(t *testing.T) {
+	id, ok := NewRequest([]byte{0x15}).FunctionID()
+	assert.True(t, ok)
+	assert.Equal(t, FuncLibraryVersion, id)
+	assert.Equal(t, "LIBRARY_VERSION", id.String())
+	assert.Equal(t, "FunctionID(0xee)", FunctionID(0xee).String())
+	assert.Equal(t, "FunctionID(0x03)", FunctionID(0x03).String())
+
+	_, ok = NewACK().FunctionID()
+	assert.False(t, ok)
+}
@@ -0,0 +1,71 @@
+package transport
+
+import "fmt"
+
+// FunctionID identifies a Serial API function. It is the first byte of the
+// payload of every data frame.
+type FunctionID byte
+
+const (
+	FuncInitData                        FunctionID = 0x02
+	FuncApplicationCommandHandler       FunctionID = 0x04
+	FuncControllerCapabilities          FunctionID = 0x05
+	FuncSetTimeouts                     FunctionID = 0x06
+	FuncCapabilities                    FunctionID = 0x07
+	FuncSoftReset                       FunctionID = 0x08
+	FuncSerialAPIStarted                FunctionID = 0x0A
+	FuncSendData                        FunctionID = 0x13
+	FuncLibraryVersion                  FunctionID = 0x15
+	FuncMemoryGetID                     FunctionID = 0x20
+	FuncNodeProtocolInfo                FunctionID = 0x41
+	FuncSetDefault                      FunctionID = 0x42
+	FuncRequestNodeNeighborUpdate       FunctionID = 0x48
+	FuncApplicationUpdate               FunctionID = 0x49
+	FuncAddNodeToNetwork                FunctionID = 0x4A
+	FuncRemoveNodeFromNetwork           FunctionID = 0x4B
+	FuncRequestNodeInfo                 FunctionID = 0x60
+	FuncRemoveFailedNode                FunctionID = 0x61
+	FuncIsFailedNode                    FunctionID = 0x62
+	FuncReplaceFailedNode               FunctionID = 0x63
+	FuncApplicationCommandHandlerBridge FunctionID = 0xA8
+)
+
+var functionNames = map[FunctionID]string{
+	FuncInitData:                        "INIT_DATA",
+	FuncApplicationCommandHandler:       "APPLICATION_COMMAND_HANDLER",
+	FuncControllerCapabilities:          "CONTROLLER_CAPABILITIES",
+	FuncSetTimeouts:                     "SET_TIMEOUTS",
+	FuncCapabilities:                    "CAPABILITIES",
+	FuncSoftReset:                       "SOFT_RESET",
+	FuncSerialAPIStarted:                "SERIAL_API_STARTED",
+	FuncSendData:                        "SEND_DATA",
+	FuncLibraryVersion:                  "LIBRARY_VERSION",
+	FuncMemoryGetID:                     "MEMORY_GET_ID",
+	FuncNodeProtocolInfo:                "NODE_PROTOCOL_INFO",
+	FuncSetDefault:                      "SET_DEFAULT",
+	FuncRequestNodeNeighborUpdate:       "REQUEST_NODE_NEIGHBOR_UPDATE",
+	FuncApplicationUpdate:               "APPLICATION_UPDATE",
+	FuncAddNodeToNetwork:                "ADD_NODE_TO_NETWORK",
+	FuncRemoveNodeFromNetwork:           "REMOVE_NODE_FROM_NETWORK",
+	FuncRequestNodeInfo:                 "REQUEST_NODE_INFO",
+	FuncRemoveFailedNode:                "REMOVE_FAILED_NODE",
+	FuncIsFailedNode:                    "IS_FAILED_NODE",
+	FuncReplaceFailedNode:               "REPLACE_FAILED_NODE",
+	FuncApplicationCommandHandlerBridge: "APPLICATION_COMMAND_HANDLER_BRIDGE",
+}
+
+func (f FunctionID) String() string {
+	if name, ok := functionNames[f]; ok {
+		return name
+	}
+	return fmt.Sprintf("FunctionID(0x%02x)", byte(f))
+}
+
+// FunctionID returns the Serial API function of a data frame, or false for
+// frames without a payload.
+func (f *Frame) FunctionID() (FunctionID, bool) {
+	if !f.IsDataFrame() || len(f.Payload) < 1 {
+		return 0, false
+	}
+	return FunctionID(f.Payload[0]), true
+}